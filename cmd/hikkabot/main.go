@@ -6,8 +6,10 @@ import (
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/dvach"
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/reddit"
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/redditsave"
+	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/redgifs"
 	"github.com/jfk9w/hikkabot/v4/internal/core"
 	"github.com/jfk9w/hikkabot/v4/internal/ext/converters"
+	"github.com/jfk9w/hikkabot/v4/internal/ext/hashers"
 	"github.com/jfk9w/hikkabot/v4/internal/ext/resolvers"
 	"github.com/jfk9w/hikkabot/v4/internal/ext/vendors"
 
@@ -38,7 +40,8 @@ type C struct {
 	} `yaml:"media,omitempty" doc:"Media downloader settings."`
 
 	FFmpeg struct {
-		Enabled bool `yaml:"enabled,omitempty" doc:"Whether ffmpeg-based media converter should be enabled. Requires ffmpeg to be present in $PATH." default:"true"`
+		Enabled                 bool `yaml:"enabled,omitempty" doc:"Whether ffmpeg-based media converter should be enabled. Requires ffmpeg to be present in $PATH." default:"true"`
+		converters.FFmpegConfig `yaml:",inline"`
 	} `yaml:"ffmpeg,omitempty" doc:"FFmpeg-related settings."`
 
 	Aconvert struct {
@@ -46,6 +49,16 @@ type C struct {
 		aconvert.Config `yaml:",inline"`
 	} `yaml:"aconvert,omitempty" doc:"aconvert.com-related settings."`
 
+	Egress core.EgressConfig `yaml:"egress,omitempty" doc:"Policy for fetching media and web pages by URLs found in feeds. Protects internal network from being accessed via such URLs."`
+
+	OpenGraph resolvers.OpenGraphConfig `yaml:"opengraph,omitempty" doc:"OpenGraph/oEmbed media resolver settings. It is also used for imgur.com pages."`
+
+	Imgur resolvers.ImgurConfig `yaml:"imgur,omitempty" doc:"imgur.com-related settings."`
+
+	Redgifs redgifs.Config `yaml:"redgifs,omitempty" doc:"redgifs.com-related settings."`
+
+	YtDlp resolvers.YtDlpConfig `yaml:"ytdlp,omitempty" doc:"yt-dlp media resolver settings."`
+
 	Dvach dvach.Config `yaml:"dvach,omitempty" doc:"2ch.hk-related settings."`
 
 	Reddit struct {
@@ -60,19 +73,25 @@ type C struct {
 	Prometheus apfel.PrometheusConfig `yaml:"prometheus,omitempty" doc:"Prometheus settings."`
 }
 
-func (c C) LogfConfig() apfel.LogfConfig             { return c.Logging }
-func (c C) PrometheusConfig() apfel.PrometheusConfig { return c.Prometheus }
-func (c C) TelegramConfig() tapp.Config              { return c.Telegram.Config }
-func (c C) InterfaceConfig() core.InterfaceConfig    { return c.Telegram.InterfaceConfig }
-func (c C) PollerConfig() core.PollerConfig          { return c.Poller }
-func (c C) StorageConfig() apfel.GormConfig          { return c.Db }
-func (c C) BlobConfig() core.BlobConfig              { return c.Media.BlobConfig }
-func (c C) RedditsaveConfig() redditsave.Config      { return c.Reddit.Redditsave }
-func (c C) AconvertConfig() aconvert.Config          { return c.Aconvert.Config }
-func (c C) MediatorConfig() core.MediatorConfig      { return c.Media.MediatorConfig }
-func (c C) DvachConfig() dvach.Config                { return c.Dvach }
-func (c C) RedditConfig() reddit.Config              { return c.Reddit.Config }
-func (c C) SubredditConfig() vendors.SubredditConfig { return c.Reddit.Posts }
+func (c C) LogfConfig() apfel.LogfConfig               { return c.Logging }
+func (c C) PrometheusConfig() apfel.PrometheusConfig   { return c.Prometheus }
+func (c C) TelegramConfig() tapp.Config                { return c.Telegram.Config }
+func (c C) InterfaceConfig() core.InterfaceConfig      { return c.Telegram.InterfaceConfig }
+func (c C) PollerConfig() core.PollerConfig            { return c.Poller }
+func (c C) StorageConfig() apfel.GormConfig            { return c.Db }
+func (c C) BlobConfig() core.BlobConfig                { return c.Media.BlobConfig }
+func (c C) RedditsaveConfig() redditsave.Config        { return c.Reddit.Redditsave }
+func (c C) AconvertConfig() aconvert.Config            { return c.Aconvert.Config }
+func (c C) FFmpegConfig() converters.FFmpegConfig      { return c.FFmpeg.FFmpegConfig }
+func (c C) MediatorConfig() core.MediatorConfig        { return c.Media.MediatorConfig }
+func (c C) EgressConfig() core.EgressConfig            { return c.Egress }
+func (c C) YtDlpConfig() resolvers.YtDlpConfig         { return c.YtDlp }
+func (c C) OpenGraphConfig() resolvers.OpenGraphConfig { return c.OpenGraph }
+func (c C) ImgurConfig() resolvers.ImgurConfig         { return c.Imgur }
+func (c C) RedgifsConfig() redgifs.Config              { return c.Redgifs }
+func (c C) DvachConfig() dvach.Config                  { return c.Dvach }
+func (c C) RedditConfig() reddit.Config                { return c.Reddit.Config }
+func (c C) SubredditConfig() vendors.SubredditConfig   { return c.Reddit.Posts }
 func (c C) SubredditSuggestionsConfig() vendors.SubredditSuggestionsConfig {
 	return c.Reddit.Suggestions
 }
@@ -111,10 +130,12 @@ func main() {
 		gorm,
 		&poller,
 		new(core.Interface[C]),
-		&resolvers.GfycatLike[C]{Name: "gfycat"},
-		&resolvers.GfycatLike[C]{Name: "redgifs"},
+		new(resolvers.Redgifs[C]),
 		new(resolvers.Imgur[C]),
+		new(resolvers.OpenGraph[C]),
+		new(resolvers.YtDlp[C]),
 		new(converters.FFmpeg[C]),
+		new(hashers.FFmpeg[C]),
 		new(resolvers.Dvach[C]),
 		vendors.DvachCatalog[C](),
 		vendors.DvachThread[C](),
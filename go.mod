@@ -3,6 +3,7 @@ module github.com/jfk9w/hikkabot/v4
 go 1.22
 
 require (
+	github.com/aws/aws-sdk-go v1.55.5
 	github.com/corona10/goimagehash v1.1.0
 	github.com/gofrs/uuid v4.4.0+incompatible
 	github.com/jfk9w-go/aconvert-api v0.11.2
@@ -23,7 +24,6 @@ require (
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.55.5 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/fatih/color v1.17.0 // indirect
@@ -7,6 +7,9 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/jfk9w-go/flu/httpf"
@@ -19,6 +22,7 @@ type Config struct {
 }
 
 type Context interface {
+	core.EgressContext
 	DvachConfig() Config
 }
 
@@ -31,11 +35,18 @@ func (c Client[C]) String() string {
 }
 
 func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	var egress core.Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
 	config := app.Config().DvachConfig()
-	return c.Standalone(ctx, config)
+	return c.Standalone(ctx, config, egress.Policy())
 }
 
-func (c *Client[C]) Standalone(ctx context.Context, config Config) error {
+// Standalone initializes the client without the application.
+// Requests are checked against egress policy if it is not nil.
+func (c *Client[C]) Standalone(ctx context.Context, config Config, egress *media.EgressPolicy) error {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return errors.Wrap(err, "create cookie jar")
@@ -49,7 +60,12 @@ func (c *Client[C]) Standalone(ctx context.Context, config Config) error {
 		logf.Get(c).Warnf(ctx, "dvach usercode is empty – hidden boards will be unavailable")
 	}
 
-	c.client = &http.Client{Jar: jar}
+	client := &http.Client{Jar: jar}
+	if egress != nil {
+		egress.Apply(client, httpf.NewDefaultTransport())
+	}
+
+	c.client = client
 
 	return nil
 }
@@ -117,8 +133,11 @@ func (c *Client[C]) GetBoards(ctx context.Context) ([]Board, error) {
 	}
 
 	var boards []Board
-	for _, value := range boardMap {
-		boards = append(boards, value...)
+	for category, value := range boardMap {
+		for _, board := range value {
+			board.Category = category
+			boards = append(boards, board)
+		}
 	}
 
 	return boards, nil
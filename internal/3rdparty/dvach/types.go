@@ -91,9 +91,18 @@ func (c *Catalog) init(boardID string) (err error) {
 	return Posts(c.Threads).init(boardID)
 }
 
+// AdultCategory is the category of NSFW boards.
+const AdultCategory = "Взрослым"
+
 type Board struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"-"`
+}
+
+// IsNSFW returns true if the board is in the adult category.
+func (b Board) IsNSFW() bool {
+	return b.Category == AdultCategory
 }
 
 type Error struct {
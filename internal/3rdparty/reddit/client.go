@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/jfk9w-go/flu/httpf"
@@ -31,6 +33,7 @@ type Config struct {
 }
 
 type Context interface {
+	core.EgressContext
 	RedditConfig() Config
 }
 
@@ -45,15 +48,21 @@ func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		owner = config.Username
 	}
 
+	// media URLs found in reddit responses are fetched with this client as well
+	var egress core.Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
+	httpClient := new(http.Client)
+	egress.Policy().Apply(httpClient, httpf.NewDefaultTransport())
+	httpClient.Transport = withUserAgent(httpClient.Transport, fmt.Sprintf(`hikkabot/%s by /u/%s`, app.Version(), owner))
+
 	token := make(chan string, 1)
 	token <- ""
 
 	c.client = &client{
-		client: &http.Client{
-			Transport: withUserAgent(
-				httpf.NewDefaultTransport(),
-				fmt.Sprintf(`hikkabot/%s by /u/%s`, app.Version(), owner)),
-		},
+		client: httpClient,
 		config: config,
 		clock:  app,
 		token:  token,
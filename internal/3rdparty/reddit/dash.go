@@ -0,0 +1,117 @@
+package reddit
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/pkg/errors"
+)
+
+// VideoHost is the host of reddit-hosted videos.
+const VideoHost = "v.redd.it"
+
+// DASHPlaylistURL returns DASH playlist URL for a v.redd.it video URL (like a fallback URL).
+// Playlist URLs are returned as is.
+func DASHPlaylistURL(videoURL *url.URL) (string, error) {
+	if strings.HasSuffix(videoURL.Path, ".mpd") {
+		return videoURL.String(), nil
+	}
+
+	id := strings.SplitN(strings.Trim(videoURL.Path, "/"), "/", 2)[0]
+	if id == "" {
+		return "", errors.Errorf("no video id in %s", videoURL)
+	}
+
+	return "https://" + VideoHost + "/" + id + "/DASHPlaylist.mpd", nil
+}
+
+// DASHRepresentation is a single video or audio stream of a DASH playlist.
+type DASHRepresentation struct {
+	MIMEType  string `xml:"mimeType,attr"`
+	Bandwidth int64  `xml:"bandwidth,attr"`
+	Height    int    `xml:"height,attr"`
+	BaseURL   string `xml:"BaseURL"`
+}
+
+type dashAdaptationSet struct {
+	ContentType     string               `xml:"contentType,attr"`
+	MIMEType        string               `xml:"mimeType,attr"`
+	Representations []DASHRepresentation `xml:"Representation"`
+}
+
+// DASHPlaylist is a parsed DASH (MPEG-DASH MPD) playlist.
+type DASHPlaylist struct {
+	URL     *url.URL `xml:"-"`
+	Periods []struct {
+		AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+// Best returns the URL of the representation with the highest bandwidth for the content type ("video" or "audio").
+// It returns an empty string if there are no such representations.
+func (p *DASHPlaylist) Best(contentType string) (string, error) {
+	var best *DASHRepresentation
+	for _, period := range p.Periods {
+		for _, set := range period.AdaptationSets {
+			for i := range set.Representations {
+				repr := &set.Representations[i]
+				if getContentType(set, repr) != contentType || repr.BaseURL == "" {
+					continue
+				}
+
+				if best == nil || repr.Bandwidth > best.Bandwidth {
+					best = repr
+				}
+			}
+		}
+	}
+
+	if best == nil {
+		return "", nil
+	}
+
+	reprURL, err := p.URL.Parse(strings.TrimSpace(best.BaseURL))
+	if err != nil {
+		return "", errors.Wrapf(err, "parse url %s", best.BaseURL)
+	}
+
+	return reprURL.String(), nil
+}
+
+// getContentType detects content type by attributes of adaptation set or representation
+// since playlists of older videos lack contentType attribute.
+func getContentType(set dashAdaptationSet, repr *DASHRepresentation) string {
+	if set.ContentType != "" {
+		return set.ContentType
+	}
+
+	mimeType := repr.MIMEType
+	if mimeType == "" {
+		mimeType = set.MIMEType
+	}
+
+	return strings.SplitN(mimeType, "/", 2)[0]
+}
+
+// GetDASHPlaylist fetches and parses the DASH playlist of a reddit-hosted video.
+func (c *client) GetDASHPlaylist(ctx context.Context, playlistURL string) (*DASHPlaylist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse url")
+	}
+
+	playlist := &DASHPlaylist{URL: base}
+	if err := httpf.GET(playlistURL).
+		Exchange(ctx, c).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.XML(playlist)).
+		Error(); err != nil {
+		return nil, err
+	}
+
+	return playlist, nil
+}
@@ -0,0 +1,99 @@
+package reddit
+
+import (
+	"encoding/xml"
+	"net/url"
+	"testing"
+)
+
+const testDASHPlaylist = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <Period>
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <Representation bandwidth="1200000" height="480"><BaseURL>DASH_480.mp4</BaseURL></Representation>
+      <Representation bandwidth="4800000" height="1080"><BaseURL> DASH_1080.mp4 </BaseURL></Representation>
+      <Representation bandwidth="2400000" height="720"><BaseURL>DASH_720.mp4</BaseURL></Representation>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4">
+      <Representation bandwidth="64000"><BaseURL>DASH_AUDIO_64.mp4</BaseURL></Representation>
+      <Representation bandwidth="128000"><BaseURL>DASH_AUDIO_128.mp4</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+// testLegacyDASHPlaylist lacks contentType attributes, and audio MIME type is set on representation.
+const testLegacyDASHPlaylist = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation bandwidth="1200000"><BaseURL>DASH_480.mp4</BaseURL></Representation>
+      <Representation bandwidth="9000000"><BaseURL></BaseURL></Representation>
+    </AdaptationSet>
+    <AdaptationSet>
+      <Representation mimeType="audio/mp4" bandwidth="64000"><BaseURL>https://cdn.example.com/audio</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+const testVideoOnlyDASHPlaylist = `<MPD><Period><AdaptationSet contentType="video">
+  <Representation bandwidth="1"><BaseURL>DASH_240.mp4</BaseURL></Representation>
+</AdaptationSet></Period></MPD>`
+
+func TestDASHPlaylistBest(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		playlist    string
+		contentType string
+		url         string
+	}{
+		{name: "best video", playlist: testDASHPlaylist, contentType: "video", url: "https://v.redd.it/abc/DASH_1080.mp4"},
+		{name: "best audio", playlist: testDASHPlaylist, contentType: "audio", url: "https://v.redd.it/abc/DASH_AUDIO_128.mp4"},
+		{name: "legacy video without url", playlist: testLegacyDASHPlaylist, contentType: "video", url: "https://v.redd.it/abc/DASH_480.mp4"},
+		{name: "legacy audio absolute url", playlist: testLegacyDASHPlaylist, contentType: "audio", url: "https://cdn.example.com/audio"},
+		{name: "no audio", playlist: testVideoOnlyDASHPlaylist, contentType: "audio"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, _ := url.Parse("https://v.redd.it/abc/DASHPlaylist.mpd")
+			playlist := &DASHPlaylist{URL: base}
+			if err := xml.Unmarshal([]byte(tc.playlist), playlist); err != nil {
+				t.Fatalf("parse playlist: %v", err)
+			}
+
+			best, err := playlist.Best(tc.contentType)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if best != tc.url {
+				t.Errorf("expected %q, got %q", tc.url, best)
+			}
+		})
+	}
+}
+
+func TestDASHPlaylistURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, playlist string
+	}{
+		{url: "https://v.redd.it/abc", playlist: "https://v.redd.it/abc/DASHPlaylist.mpd"},
+		{url: "https://v.redd.it/abc/DASH_720.mp4?source=fallback", playlist: "https://v.redd.it/abc/DASHPlaylist.mpd"},
+		{url: "https://v.redd.it/abc/DASHPlaylist.mpd?a=1", playlist: "https://v.redd.it/abc/DASHPlaylist.mpd?a=1"},
+		{url: "https://v.redd.it/"},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			videoURL, _ := url.Parse(tc.url)
+			playlist, err := DASHPlaylistURL(videoURL)
+			if tc.playlist == "" {
+				if err == nil {
+					t.Errorf("expected error, got %s", playlist)
+				}
+
+				return
+			}
+
+			if err != nil || playlist != tc.playlist {
+				t.Errorf("expected %s, got %s (%v)", tc.playlist, playlist, err)
+			}
+		})
+	}
+}
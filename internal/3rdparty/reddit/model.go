@@ -43,6 +43,8 @@ type ThingData struct {
 	Ups                 int         `json:"ups" gorm:"not null"`
 	SelfTextHTML        string      `json:"selftext_html" gorm:"-"`
 	IsSelf              bool        `json:"is_self" gorm:"not null"`
+	Over18              bool        `json:"over_18" gorm:"-"`
+	Spoiler             bool        `json:"spoiler" gorm:"-"`
 	CreatedSecs         float32     `json:"created_utc" gorm:"-"`
 	MediaContainer      `gorm:"-"`
 	CrosspostParentList []MediaContainer `json:"crosspost_parent_list" gorm:"-"`
@@ -6,6 +6,9 @@ import (
 	"net/http/cookiejar"
 	"time"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
 	"github.com/jfk9w-go/flu/logf"
 
 	"github.com/jfk9w-go/flu/apfel"
@@ -21,10 +24,12 @@ import (
 var URL = "https://redditsave.com"
 
 type Config struct {
+	Enabled      bool         `yaml:"enabled,omitempty" doc:"Whether redditsave.com should be used to resolve v.redd.it videos when they can not be resolved natively."`
 	RefreshEvery flu.Duration `yaml:"refreshEvery,omitempty" doc:"Cookie refresh interval" default:"20m"`
 }
 
 type Context interface {
+	core.EgressContext
 	RedditsaveConfig() Config
 }
 
@@ -33,13 +38,25 @@ type Client[C Context] struct {
 }
 
 func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	var egress core.Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
 	config := app.Config().RedditsaveConfig()
-	return c.Standalone(ctx, app, config.RefreshEvery.Value)
+	return c.Standalone(ctx, app, config.RefreshEvery.Value, egress.Policy())
 }
 
-func (c *Client[C]) Standalone(ctx context.Context, clock syncf.Clock, refreshEvery time.Duration) error {
+// Standalone initializes the client without the application.
+// Requests are checked against egress policy if it is not nil.
+func (c *Client[C]) Standalone(ctx context.Context, clock syncf.Clock, refreshEvery time.Duration, egress *media.EgressPolicy) error {
+	httpClient := new(http.Client)
+	if egress != nil {
+		egress.Apply(httpClient, httpf.NewDefaultTransport())
+	}
+
 	c.client = &client{
-		client:       new(http.Client),
+		client:       httpClient,
 		clock:        clock,
 		refreshEvery: refreshEvery,
 	}
@@ -0,0 +1,157 @@
+package redgifs
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+const (
+	HD = "hd"
+	SD = "sd"
+)
+
+type Config struct {
+	Quality   string       `yaml:"quality,omitempty" doc:"Preferred video quality. The other one is used if preferred is not available." enum:"hd,sd" default:"hd"`
+	UserAgent string       `yaml:"userAgent,omitempty" doc:"User agent used for all requests. Temporary tokens are bound to it." default:"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"`
+	TokenTTL  flu.Duration `yaml:"tokenTtl,omitempty" doc:"How long to reuse a temporary API token. It is refreshed earlier if rejected." default:"12h"`
+}
+
+type Context interface {
+	core.EgressContext
+	RedgifsConfig() Config
+}
+
+type Client[C Context] struct {
+	*client
+}
+
+func (c Client[C]) String() string {
+	return "redgifs.client"
+}
+
+func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	var egress core.Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
+	config := app.Config().RedgifsConfig()
+	return c.Standalone(ctx, app, config, egress.Policy())
+}
+
+// Standalone initializes the client without the application.
+// Media URLs returned by the API are fetched with the client, so they are checked against egress policy if it is not nil.
+func (c *Client[C]) Standalone(ctx context.Context, clock syncf.Clock, config Config, egress *media.EgressPolicy) error {
+	httpClient := new(http.Client)
+	if egress != nil {
+		egress.Apply(httpClient, httpf.NewDefaultTransport())
+	}
+
+	c.client = &client{
+		client:    httpClient,
+		clock:     clock,
+		userAgent: config.UserAgent,
+		tokenTTL:  config.TokenTTL.Value,
+	}
+
+	return nil
+}
+
+type client struct {
+	client    *http.Client
+	clock     syncf.Clock
+	userAgent string
+	tokenTTL  time.Duration
+
+	token     string
+	expiresAt time.Time
+	mu        syncf.RWMutex
+}
+
+func (c *client) String() string {
+	return "redgifs.client"
+}
+
+// Do executes the request with headers required by redgifs.com (both API and media hosts).
+func (c *client) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Referer", Referer)
+	resp, err := c.client.Do(req)
+	logf.Get(c).Resultf(req.Context(), logf.Trace, logf.Warn, "%s => %v", &httpf.RequestBuilder{Request: req}, err)
+	return resp, err
+}
+
+func (c *client) GetGif(ctx context.Context, id string) (*Gif, error) {
+	gif, err := c.getGif(ctx, id, false)
+	var statusErr httpf.StatusCodeError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+		// token may have been revoked before its expiration
+		gif, err = c.getGif(ctx, id, true)
+	}
+
+	return gif, err
+}
+
+func (c *client) getGif(ctx context.Context, id string, refresh bool) (*Gif, error) {
+	token, err := c.getToken(ctx, refresh)
+	if err != nil {
+		return nil, errors.Wrap(err, "get token")
+	}
+
+	var resp gifResponse
+	if err := httpf.GET(APIURL+"/v2/gifs/"+strings.ToLower(id)).
+		Auth(httpf.Bearer(token)).
+		Exchange(ctx, c).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON(&resp)).
+		Error(); err != nil {
+		return nil, err
+	}
+
+	return &resp.Gif, nil
+}
+
+func (c *client) getToken(ctx context.Context, refresh bool) (string, error) {
+	ctx, cancel := c.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	defer cancel()
+
+	now := c.clock.Now()
+	if !refresh && c.token != "" && now.Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	var resp tokenResponse
+	err := httpf.GET(APIURL+"/v2/auth/temporary").
+		Exchange(ctx, c).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON(&resp)).
+		Error()
+	logf.Get(c).Resultf(ctx, logf.Debug, logf.Error, "refresh token: %v", err)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Token == "" {
+		return "", errors.New("empty token")
+	}
+
+	c.token = resp.Token
+	c.expiresAt = now.Add(c.tokenTTL)
+	return c.token, nil
+}
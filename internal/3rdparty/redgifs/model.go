@@ -0,0 +1,22 @@
+package redgifs
+
+type URLs struct {
+	HD string `json:"hd"`
+	SD string `json:"sd"`
+}
+
+type Gif struct {
+	ID       string  `json:"id"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Duration float64 `json:"duration"`
+	URLs     URLs    `json:"urls"`
+}
+
+type gifResponse struct {
+	Gif Gif `json:"gif"`
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+}
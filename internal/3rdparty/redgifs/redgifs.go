@@ -0,0 +1,12 @@
+package redgifs
+
+import "context"
+
+var (
+	APIURL  = "https://api.redgifs.com"
+	Referer = "https://www.redgifs.com/"
+)
+
+type Interface interface {
+	GetGif(ctx context.Context, id string) (*Gif, error)
+}
@@ -2,24 +2,60 @@ package core
 
 import (
 	"context"
+	"net/http"
 	"os"
 
 	"github.com/jfk9w/hikkabot/v4/internal/core/internal/blobs"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/pkg/errors"
 )
 
+const (
+	MemoryBlobBackend = "memory"
+	FileBlobBackend   = "file"
+	S3BlobBackend     = "s3"
+)
+
+type S3BlobConfig struct {
+	Endpoint  string `yaml:"endpoint,omitempty" doc:"Object storage endpoint URL. Leave empty for AWS S3."`
+	Region    string `yaml:"region,omitempty" doc:"Object storage region." default:"us-east-1"`
+	Bucket    string `yaml:"bucket,omitempty" doc:"Bucket for cached files. It must exist beforehand."`
+	Prefix    string `yaml:"prefix,omitempty" doc:"Key prefix for cached files." default:"blobs/"`
+	AccessKey string `yaml:"accessKey,omitempty" doc:"Access key ID. Default AWS credential chain is used if empty."`
+	SecretKey string `yaml:"secretKey,omitempty" doc:"Secret access key."`
+	PathStyle bool   `yaml:"pathStyle,omitempty" doc:"Whether path-style addressing should be used. Most S3-compatible storages (like MinIO) require it."`
+}
+
+type DownloadConfig struct {
+	Parallelism int        `yaml:"parallelism,omitempty" doc:"How many chunks of a single file to download concurrently if the server accepts ranged requests. Values less than 2 disable parallel downloads." default:"4"`
+	ChunkSize   media.Size `yaml:"chunkSize,omitempty" doc:"Chunk size for parallel downloads. Only files larger than chunk size are downloaded in parallel." pattern:"^(\\d+)([KMGT])?$" default:"8M"`
+	Retries     int        `yaml:"retries,omitempty" doc:"How many times to resume a download (or a chunk) after a failure." default:"3"`
+}
+
 type BlobConfig struct {
+	Backend string       `yaml:"backend,omitempty" doc:"Where to keep cached files. memory is suitable only for small files, file uses a local temporary directory, s3 uses S3-compatible object storage." enum:"memory,file,s3" default:"file"`
 	MinSize media.Size   `yaml:"minSize,omitempty" doc:"Minimum media file size." pattern:"^(\\d+)([KMGT])?$" default:"1K"`
 	MaxSize media.Size   `yaml:"maxSize,omitempty" doc:"Maximum media file size." pattern:"^(\\d+)([KMGT])?$" default:"50M"`
 	TTL     flu.Duration `yaml:"ttl,omitempty" doc:"How long to keep cached files." default:"15m"`
+	Quota   media.Size   `yaml:"quota,omitempty" doc:"Total disk quota for cached files. Least recently used files are evicted when it is exceeded. Zero means no quota. Used only with file backend." pattern:"^(\\d+)([KMGT])?$" default:"0"`
+
+	CleanInterval flu.Duration `yaml:"cleanInterval,omitempty" doc:"How often to remove expired cached files. Zero disables background cleanup." default:"1m"`
+
+	Download DownloadConfig `yaml:"download,omitempty" doc:"HTTP download settings. Used only with file backend."`
+
+	S3 S3BlobConfig `yaml:"s3,omitempty" doc:"S3-compatible object storage settings. Used only with s3 backend."`
 }
 
 type BlobContext interface {
+	apfel.PrometheusContext
 	BlobConfig() BlobConfig
 }
 
@@ -31,30 +67,120 @@ func (b Blobs[C]) String() string {
 	return blobs.ServiceID
 }
 
+// Local returns true if blobs are stored in local files, so that external tools may write to them directly.
+func (b *Blobs[C]) Local() bool {
+	_, ok := b.Blobs.(*blobs.Files)
+	return ok
+}
+
+// TempDir creates a temporary directory for files written by external tools (like ffmpeg or yt-dlp).
+// It is created inside the blob directory if blobs are stored in local files,
+// so that the resulting files may be moved into blob storage (and accounted for in disk quota) without copying.
+func (b *Blobs[C]) TempDir(pattern string) (string, error) {
+	if files, ok := b.Blobs.(*blobs.Files); ok {
+		return files.TempDir(pattern)
+	}
+
+	return os.MkdirTemp(os.TempDir(), pattern)
+}
+
 func (b *Blobs[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 	if b.Blobs != nil {
 		return nil
 	}
 
-	dir, err := os.MkdirTemp(os.TempDir(), "blobs-")
-	if err != nil {
-		return errors.Wrapf(err, "create temporary directory")
+	var metrics apfel.Prometheus[C]
+	if err := app.Use(ctx, &metrics, false); err != nil {
+		return err
 	}
 
 	config := app.Config().BlobConfig()
-	blobs := &blobs.Files{
-		Clock:      app,
-		Dir:        dir,
-		TTL:        config.TTL.Value,
-		SizeBounds: [2]media.Size{config.MinSize, config.MaxSize},
+	registry := metrics.Registry().WithPrefix("app_blobs")
+	sizeBounds := [2]media.Size{config.MinSize, config.MaxSize}
+
+	var service feed.Blobs
+	switch config.Backend {
+	case MemoryBlobBackend:
+		service = &blobs.Memory{
+			SizeBounds: sizeBounds,
+		}
+
+	case S3BlobBackend:
+		client, err := newS3Client(config.S3)
+		if err != nil {
+			return err
+		}
+
+		service = &blobs.S3{
+			Clock:         app,
+			Client:        client,
+			Bucket:        config.S3.Bucket,
+			Prefix:        config.S3.Prefix,
+			TTL:           config.TTL.Value,
+			SizeBounds:    sizeBounds,
+			CleanInterval: config.CleanInterval.Value,
+			Metrics:       registry,
+		}
+
+	default:
+		dir, err := os.MkdirTemp(os.TempDir(), "blobs-")
+		if err != nil {
+			return errors.Wrapf(err, "create temporary directory")
+		}
+
+		service = &blobs.Files{
+			Clock:         app,
+			Dir:           dir,
+			TTL:           config.TTL.Value,
+			SizeBounds:    sizeBounds,
+			Quota:         config.Quota,
+			CleanInterval: config.CleanInterval.Value,
+			Metrics:       registry,
+			Download: media.DownloadOptions{
+				Parallelism: config.Download.Parallelism,
+				ChunkSize:   config.Download.ChunkSize,
+				Retries:     config.Download.Retries,
+			},
+		}
 	}
 
-	if err := app.Manage(ctx, blobs); err != nil {
+	if err := app.Manage(ctx, service); err != nil {
 		return err
 	}
 
-	b.Blobs = blobs
+	b.Blobs = service
 	return nil
 }
 
-var SkipSizeCheck = blobs.SkipSizeCheck
+func newS3Client(config S3BlobConfig) (*s3.S3, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(config.Region),
+		S3ForcePathStyle: aws.Bool(config.PathStyle),
+		// default HTTP client is restricted by egress policy, which would block private endpoints and instance metadata
+		HTTPClient: new(http.Client),
+	}
+
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+
+	if config.AccessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, "")
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "create s3 session")
+	}
+
+	return s3.New(sess), nil
+}
+
+var (
+	SkipSizeCheck = blobs.SkipSizeCheck
+	ErrTooLarge   = blobs.ErrTooLarge
+)
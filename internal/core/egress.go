@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
+)
+
+const egressServiceID = "core.egress"
+
+type EgressConfig struct {
+	AllowPrivate bool     `yaml:"allowPrivate,omitempty" doc:"Whether media and web pages may be fetched from private, loopback and link-local addresses."`
+	Allow        []string `yaml:"allow,omitempty" doc:"If not empty, only these hosts (including their subdomains), IP addresses and CIDR networks may be fetched. Hosts resolving to private addresses additionally require allowPrivate, while IP addresses and networks are allowed as is. Vendor API hosts (like reddit.com) need to be allowed as well."`
+	Deny         []string `yaml:"deny,omitempty" doc:"Hosts (including their subdomains), IP addresses and CIDR networks which may never be fetched."`
+	MaxRedirects int      `yaml:"maxRedirects,omitempty" doc:"Maximum number of redirects to follow." default:"5"`
+}
+
+type EgressContext interface {
+	apfel.PrometheusContext
+	EgressConfig() EgressConfig
+}
+
+// Egress applies the egress policy to the default HTTP client.
+// The default client is used for fetching arbitrary URLs found in feeds (like media and web pages).
+// Vendor clients apply the policy themselves since they fetch media URLs found in API responses,
+// while Telegram client is not affected.
+type Egress[C EgressContext] struct {
+	policy *media.EgressPolicy
+}
+
+func (e Egress[C]) String() string {
+	return egressServiceID
+}
+
+func (e *Egress[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	if e.policy != nil {
+		return nil
+	}
+
+	var metrics apfel.Prometheus[C]
+	if err := app.Use(ctx, &metrics, false); err != nil {
+		return err
+	}
+
+	config := app.Config().EgressConfig()
+	allow, err := media.ParseEgressRules(config.Allow)
+	if err != nil {
+		return errors.Wrap(err, "parse allow")
+	}
+
+	deny, err := media.ParseEgressRules(config.Deny)
+	if err != nil {
+		return errors.Wrap(err, "parse deny")
+	}
+
+	policy := &media.EgressPolicy{
+		AllowPrivate: config.AllowPrivate,
+		Allow:        allow,
+		Deny:         deny,
+		MaxRedirects: config.MaxRedirects,
+		Metrics:      metrics.Registry().WithPrefix("app_egress"),
+	}
+
+	policy.Apply(http.DefaultClient, httpf.NewDefaultTransport())
+	if config.AllowPrivate {
+		logf.Get(e).Warnf(ctx, "private addresses are allowed – media URLs from feeds may access internal network")
+	}
+
+	e.policy = policy
+	return nil
+}
+
+// Policy returns the applied egress policy.
+// It should be checked by external tools which fetch URLs by themselves.
+func (e *Egress[C]) Policy() *media.EgressPolicy {
+	return e.policy
+}
@@ -14,12 +14,15 @@ import (
 type InterfaceConfig struct {
 	SupervisorID telegram.ID            `yaml:"supervisorId" doc:"Telegram admin user ID. If not set, only public commands (e.g. /start) will be available."`
 	Aliases      map[string]telegram.ID `yaml:"aliases,omitempty" doc:"Chat aliases to use in commands: keys are aliases and values are telegram IDs."`
+
+	DuplicateNotices bool `yaml:"duplicateNotices,omitempty" doc:"Whether to notify supervisor about media dropped as duplicates. Notifications contain a button to send the media anyway."`
 }
 
 type InterfaceContext interface {
 	tapp.Context
 	StorageContext
 	PollerContext
+	MediatorContext
 	InterfaceConfig() InterfaceConfig
 }
 
@@ -43,6 +46,11 @@ func (i *Interface[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 		return err
 	}
 
+	var mediator Mediator[C]
+	if err := app.Use(ctx, &mediator, false); err != nil {
+		return err
+	}
+
 	config := app.Config().InterfaceConfig()
 	if config.SupervisorID == 0 {
 		logf.Get(i).Warnf(ctx, "telegram supervisor ID is not set – subscription management is disabled; "+
@@ -50,11 +58,14 @@ func (i *Interface[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 	}
 
 	i.Impl = &iface.Impl{
-		Telegram:     bot.Bot(),
-		Poller:       poller,
-		Storage:      storage,
-		SupervisorID: config.SupervisorID,
-		Aliases:      config.Aliases,
+		Telegram:         bot.Bot(),
+		Poller:           poller,
+		Storage:          storage,
+		MediaHashes:      storage,
+		Mediator:         mediator,
+		SupervisorID:     config.SupervisorID,
+		Aliases:          config.Aliases,
+		DuplicateNotices: config.DuplicateNotices,
 	}
 
 	return nil
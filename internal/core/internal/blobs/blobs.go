@@ -1,9 +1,19 @@
 package blobs
 
-import "context"
+import (
+	"context"
+	"sync"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/pkg/errors"
+)
 
 const ServiceID = "core.blobs"
 
+// ErrTooLarge is returned when blob size exceeds the upper size bound.
+var ErrTooLarge = errors.New("too large")
+
 type skipSizeCheckKey struct{}
 
 func SkipSizeCheck(ctx context.Context) context.Context {
@@ -14,3 +24,64 @@ func skipSizeCheck(ctx context.Context) bool {
 	_, ok := ctx.Value(skipSizeCheckKey{}).(bool)
 	return ok
 }
+
+type holderKey struct{}
+
+// holder keeps writer references to blobs stored within its context.
+type holder struct {
+	releases []func()
+	released bool
+	mu       sync.Mutex
+}
+
+// Hold returns a context in which stored blobs keep their writer references until `release` is called.
+// This protects intermediate blobs (like downloads buffered for hashing or conversion) from eviction
+// until the whole mediation finishes.
+func Hold(ctx context.Context) (context.Context, func()) {
+	h := new(holder)
+	var once sync.Once
+	return context.WithValue(ctx, holderKey{}, h), func() { once.Do(h.release) }
+}
+
+// hold registers `release` to be called when the context holder is released.
+// It returns false if there is no holder in the context or it has already been released.
+func hold(ctx context.Context, release func()) bool {
+	h, ok := ctx.Value(holderKey{}).(*holder)
+	if !ok {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.released {
+		return false
+	}
+
+	h.releases = append(h.releases, release)
+	return true
+}
+
+func (h *holder) release() {
+	h.mu.Lock()
+	releases := h.releases
+	h.releases, h.released = nil, true
+	h.mu.Unlock()
+	for _, release := range releases {
+		release()
+	}
+}
+
+func checkSize(ctx context.Context, bounds [2]media.Size, size media.Size) error {
+	if skipSizeCheck(ctx) || size <= 0 {
+		return nil
+	}
+
+	switch {
+	case size < bounds[0]:
+		return errors.Errorf("size %s too low", size)
+	case size >= bounds[1]:
+		return errors.Wrapf(ErrTooLarge, "size %s", size)
+	}
+
+	return nil
+}
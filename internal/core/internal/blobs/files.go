@@ -3,6 +3,7 @@ package blobs
 import (
 	"context"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,26 +12,64 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/me3x"
 	"github.com/jfk9w-go/flu/syncf"
 	"github.com/pkg/errors"
 )
 
+// ErrQuotaExceeded is returned when a blob file does not fit into the disk quota
+// even after all unused files were evicted.
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
 type Files struct {
-	Clock      syncf.Clock
-	TTL        time.Duration
-	Dir        string
-	SizeBounds [2]media.Size
-	files      map[flu.File]time.Time
-	once       sync.Once
-	mu         syncf.RWMutex
+	Clock         syncf.Clock
+	TTL           time.Duration
+	Dir           string
+	SizeBounds    [2]media.Size
+	Quota         media.Size
+	CleanInterval time.Duration
+	Metrics       me3x.Registry
+	Download      media.DownloadOptions
+
+	files  map[flu.File]*fileEntry
+	usage  int64
+	once   sync.Once
+	mu     syncf.RWMutex
+	cancel func()
+	work   syncf.WaitGroup
+}
+
+// fileEntry tracks blob file usage.
+// Files with non-zero reference count are never evicted until either all references are released or lease expires.
+type fileEntry struct {
+	size        int64
+	lastUsed    time.Time
+	refs        int
+	leasedUntil time.Time
 }
 
 func (fs *Files) String() string {
 	return ServiceID
 }
 
+func (fs *Files) init() {
+	fs.files = make(map[flu.File]*fileEntry)
+	fs.Metrics.Gauge("quota_bytes", nil).Set(float64(fs.Quota))
+	if fs.CleanInterval > 0 {
+		var ctx context.Context
+		ctx, fs.cancel = context.WithCancel(context.Background())
+		_, _ = syncf.GoWith(ctx, fs.work.Spawn, fs.runJanitor)
+	}
+}
+
+// TempDir creates a temporary directory inside the blob directory.
+// Temporary files created there may be moved into blob storage without copying.
+func (fs *Files) TempDir(pattern string) (string, error) {
+	return os.MkdirTemp(fs.Dir, pattern)
+}
+
 func (fs *Files) Buffer(mimeType string, ref media.Ref) media.MetaRef {
-	fs.once.Do(func() { fs.files = make(map[flu.File]time.Time) })
+	fs.once.Do(fs.init)
 	return &fileRef{
 		fs:   fs,
 		meta: media.Meta{MIMEType: mimeType},
@@ -38,6 +77,45 @@ func (fs *Files) Buffer(mimeType string, ref media.Ref) media.MetaRef {
 	}
 }
 
+// Acquire marks the blob file as in use, so that it is not evicted until released.
+// The reference is also released automatically after TTL.
+func (fs *Files) Acquire(ctx context.Context, input flu.Input) (release func()) {
+	fs.once.Do(fs.init)
+	file, ok := input.(flu.File)
+	if !ok {
+		return func() {}
+	}
+
+	ctx, cancel := fs.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return func() {}
+	}
+
+	defer cancel()
+	entry, ok := fs.files[file]
+	if !ok {
+		return func() {}
+	}
+
+	now := fs.Clock.Now()
+	entry.refs++
+	entry.lastUsed = now
+	entry.leasedUntil = now.Add(fs.TTL)
+
+	var once sync.Once
+	return func() { once.Do(func() { fs.release(file) }) }
+}
+
+func (fs *Files) release(file flu.File) {
+	ctx, cancel := fs.mu.Lock(context.Background())
+	defer cancel()
+	if entry, ok := fs.files[file]; ok && entry.refs > 0 {
+		entry.refs--
+		entry.lastUsed = fs.Clock.Now()
+		fs.evict(ctx, 0)
+	}
+}
+
 func (fs *Files) alloc(ctx context.Context) (flu.File, error) {
 	ctx, cancel := fs.mu.Lock(ctx)
 	if ctx.Err() != nil {
@@ -47,20 +125,192 @@ func (fs *Files) alloc(ctx context.Context) (flu.File, error) {
 	defer cancel()
 
 	now := fs.Clock.Now()
-	for file, createdAt := range fs.files {
-		if now.Sub(createdAt) > fs.TTL {
-			err := file.Remove()
-			logf.Get(fs).Resultf(ctx, logf.Debug, logf.Warn, "remove blob file [%s]: %v", file, err)
-		}
+	file := flu.File(fs.Dir + "/" + uuid.Must(uuid.NewV4()).String())
+	fs.files[file] = &fileEntry{
+		lastUsed:    now,
+		refs:        1,
+		leasedUntil: now.Add(fs.TTL),
 	}
 
-	file := flu.File(fs.Dir + "/" + uuid.Must(uuid.NewV4()).String())
-	fs.files[file] = now
 	logf.Get(fs).Debugf(ctx, "allocated new file blob [%s]", file)
 	return file, nil
 }
 
+// commit records the size of a written blob file and enforces disk quota.
+func (fs *Files) commit(ctx context.Context, file flu.File, size int64) error {
+	ctx, cancel := fs.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	defer cancel()
+	entry, ok := fs.files[file]
+	if !ok {
+		return errors.New("blob file has been evicted")
+	}
+
+	entry.lastUsed = fs.Clock.Now()
+	fs.usage += size - entry.size
+	entry.size = size
+	defer fs.updateMetrics()
+	// the file is still referenced during eviction, so only other files may be evicted
+	fs.evict(ctx, 0)
+	if fs.Quota > 0 && fs.usage > int64(fs.Quota) {
+		fs.remove(ctx, file, "quota")
+		return errors.Wrapf(ErrQuotaExceeded, "size %s", media.Size(size))
+	}
+
+	// writer reference is kept until the context holder is released (if there is one)
+	if !hold(ctx, func() { fs.release(file) }) {
+		entry.refs--
+	}
+
+	return nil
+}
+
+// store allocates a new blob file, writes it and commits its size.
+func (fs *Files) store(ctx context.Context, write func(file flu.File) (int64, error)) (flu.File, error) {
+	file, err := fs.alloc(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	size, err := write(file)
+	if err != nil {
+		fs.discard(ctx, file)
+		return "", err
+	}
+
+	if err := fs.commit(ctx, file, size); err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
+
+// discard removes a blob file which could not be written.
+func (fs *Files) discard(ctx context.Context, file flu.File) {
+	ctx, cancel := fs.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	if _, ok := fs.files[file]; ok {
+		fs.remove(ctx, file, "")
+	}
+}
+
+func (fs *Files) touch(ctx context.Context, file flu.File) {
+	ctx, cancel := fs.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	if entry, ok := fs.files[file]; ok {
+		entry.lastUsed = fs.Clock.Now()
+	}
+}
+
+// evict removes least recently used files which are not in use until disk usage fits into quota.
+// Files with expired TTL are removed if `expire` is set.
+func (fs *Files) evict(ctx context.Context, expire time.Duration) {
+	now := fs.Clock.Now()
+	candidates := make([]flu.File, 0)
+	for file, entry := range fs.files {
+		if entry.refs > 0 && now.After(entry.leasedUntil) {
+			logf.Get(fs).Warnf(ctx, "lease for blob file [%s] expired with %d references", file, entry.refs)
+			entry.refs = 0
+		}
+
+		if entry.refs > 0 {
+			continue
+		}
+
+		if expire > 0 && now.Sub(entry.lastUsed) > expire {
+			fs.remove(ctx, file, "ttl")
+			continue
+		}
+
+		candidates = append(candidates, file)
+	}
+
+	if fs.Quota <= 0 || fs.usage <= int64(fs.Quota) {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return fs.files[candidates[i]].lastUsed.Before(fs.files[candidates[j]].lastUsed)
+	})
+
+	for _, file := range candidates {
+		if fs.usage <= int64(fs.Quota) {
+			break
+		}
+
+		fs.remove(ctx, file, "quota")
+	}
+}
+
+func (fs *Files) remove(ctx context.Context, file flu.File, reason string) {
+	entry := fs.files[file]
+	delete(fs.files, file)
+	fs.usage -= entry.size
+	err := file.Remove()
+	if os.IsNotExist(err) {
+		err = nil
+	}
+
+	logf.Get(fs).Resultf(ctx, logf.Debug, logf.Warn, "remove blob file [%s]: %v", file, err)
+	if reason != "" {
+		fs.Metrics.Counter("evicted", make(me3x.Labels, 0, 1).Add("reason", reason)).Inc()
+	}
+}
+
+func (fs *Files) updateMetrics() {
+	fs.Metrics.Gauge("usage_bytes", nil).Set(float64(fs.usage))
+	fs.Metrics.Gauge("files", nil).Set(float64(len(fs.files)))
+}
+
+func (fs *Files) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(fs.CleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fs.clean(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (fs *Files) clean(ctx context.Context) {
+	ctx, cancel := fs.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	// blob files may be written directly by external tools (like ffmpeg), so sizes need to be refreshed
+	for file, entry := range fs.files {
+		if stat, err := os.Stat(file.String()); err == nil {
+			fs.usage += stat.Size() - entry.size
+			entry.size = stat.Size()
+		}
+	}
+
+	fs.evict(ctx, fs.TTL)
+	fs.updateMetrics()
+}
+
 func (fs *Files) Close() error {
+	if fs.cancel != nil {
+		fs.cancel()
+		fs.work.Wait()
+	}
+
 	return os.RemoveAll(fs.Dir)
 }
 
@@ -80,30 +330,20 @@ func (r *fileRef) GetMeta(ctx context.Context) (*media.Meta, error) {
 
 func (r *fileRef) Get(ctx context.Context) (flu.Input, error) {
 	r.once.Do(func() { r.get(ctx) })
+	if r.err == nil {
+		r.fs.touch(ctx, r.file)
+	}
+
 	return r.file, r.err
 }
 
 func (r *fileRef) get(ctx context.Context) {
-	input, err := r.ref.Get(ctx)
+	file, err := r.write(ctx)
 	if err != nil {
 		r.err = err
 		return
 	}
 
-	file, ok := input.(flu.File)
-	if !ok {
-		file, err = r.fs.alloc(ctx)
-		if err != nil {
-			r.err = err
-			return
-		}
-
-		if _, err := flu.Copy(input, file); err != nil {
-			r.err = err
-			return
-		}
-	}
-
 	stat, err := os.Stat(file.String())
 	if err != nil {
 		r.err = err
@@ -111,22 +351,47 @@ func (r *fileRef) get(ctx context.Context) {
 	}
 
 	r.file = file
-
-	if skipSizeCheck(ctx) {
+	size := media.Size(stat.Size())
+	if err := checkSize(ctx, r.fs.SizeBounds, size); err != nil {
+		r.err = err
 		return
 	}
 
-	size := media.Size(stat.Size())
-	if size > 0 {
-		switch {
-		case size < r.fs.SizeBounds[0]:
-			r.err = errors.Errorf("size %s too low", size)
-			return
-		case size >= r.fs.SizeBounds[1]:
-			r.err = errors.Errorf("size %s too large", size)
-			return
-		}
+	if !skipSizeCheck(ctx) {
+		r.meta.Size = size
+	}
+}
+
+// write stores the media into a new blob file unless it is a local file already.
+func (r *fileRef) write(ctx context.Context) (flu.File, error) {
+	if downloader, ok := r.ref.(media.Downloader); ok {
+		return r.fs.store(ctx, func(file flu.File) (int64, error) {
+			return downloader.Download(ctx, file, r.fs.Download)
+		})
 	}
 
-	r.meta.Size = media.Size(stat.Size())
+	input, err := r.ref.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch input := input.(type) {
+	case flu.File:
+		return input, nil
+	case media.TempFile:
+		return r.fs.store(ctx, input.MoveTo)
+	}
+
+	return r.fs.store(ctx, func(file flu.File) (int64, error) {
+		if _, err := flu.Copy(input, file); err != nil {
+			return 0, err
+		}
+
+		stat, err := os.Stat(file.String())
+		if err != nil {
+			return 0, err
+		}
+
+		return stat.Size(), nil
+	})
 }
@@ -0,0 +1,202 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+func newTestFiles(t *testing.T, quota media.Size) *Files {
+	t.Helper()
+	fs := &Files{
+		Clock:      syncf.DefaultClock,
+		TTL:        time.Hour,
+		Dir:        t.TempDir(),
+		SizeBounds: [2]media.Size{1, 1 << 20},
+		Quota:      quota,
+		Metrics:    me3x.DummyRegistry{},
+	}
+
+	t.Cleanup(func() { _ = fs.Close() })
+	return fs
+}
+
+func bufferTestFile(ctx context.Context, fs *Files, size int) (flu.File, error) {
+	input, err := fs.Buffer("", syncf.Val[flu.Input]{V: flu.Bytes(bytes.Repeat([]byte{1}, size))}).Get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return input.(flu.File), nil
+}
+
+func fileExists(file flu.File) bool {
+	_, err := os.Stat(file.String())
+	return err == nil
+}
+
+func TestFilesEvictLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFiles(t, 250)
+	first, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fileExists(first) || !fileExists(second) || !fileExists(third) {
+		t.Errorf("expected only the least recently used file to be evicted")
+	}
+}
+
+// TestFilesHoldInFlight checks that blobs stored during mediation are not evicted under quota pressure
+// until the mediation finishes.
+func TestFilesHoldInFlight(t *testing.T) {
+	fs := newTestFiles(t, 150)
+	ctx, release := Hold(context.Background())
+	held, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bufferTestFile(ctx, fs, 100); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected quota exceeded error, got %v", err)
+	}
+
+	if !fileExists(held) {
+		t.Fatal("held file has been evicted")
+	}
+
+	release()
+	release()
+	if _, err := bufferTestFile(context.Background(), fs, 100); err != nil {
+		t.Fatalf("unexpected error after release: %v", err)
+	}
+
+	if fileExists(held) {
+		t.Error("released file has not been evicted")
+	}
+
+	// blobs stored after release are not held
+	if _, err := bufferTestFile(ctx, fs, 100); err != nil {
+		t.Fatalf("unexpected error after release: %v", err)
+	}
+}
+
+func TestFilesAcquire(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFiles(t, 150)
+	acquired, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := fs.Acquire(ctx, acquired)
+	if _, err := bufferTestFile(ctx, fs, 100); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected quota exceeded error, got %v", err)
+	}
+
+	release()
+	if _, err := bufferTestFile(ctx, fs, 100); err != nil {
+		t.Fatalf("unexpected error after release: %v", err)
+	}
+
+	if fileExists(acquired) {
+		t.Error("released file has not been evicted")
+	}
+}
+
+// TestFilesClean checks that the janitor removes files which have not been used for TTL,
+// keeps the acquired ones until their lease expires and refreshes sizes of files written externally.
+func TestFilesClean(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := newTestFiles(t, 0)
+	fs.Clock = syncf.ClockFunc(func() time.Time { return now })
+	expired, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(fs.TTL / 2)
+	fs.Acquire(ctx, used)()
+	acquired, err := bufferTestFile(ctx, fs, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := fs.Acquire(ctx, acquired)
+	defer release()
+
+	if err := os.WriteFile(used.String(), bytes.Repeat([]byte{1}, 150), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(fs.TTL/2 + time.Minute)
+	fs.clean(ctx)
+	switch {
+	case fileExists(expired):
+		t.Error("expired file has not been removed")
+	case !fileExists(used) || !fileExists(acquired):
+		t.Error("recently used file has been removed")
+	case fs.usage != 250:
+		t.Errorf("expected usage 250, got %d", fs.usage)
+	}
+
+	now = now.Add(2 * fs.TTL)
+	fs.clean(ctx)
+	if fileExists(used) || fileExists(acquired) {
+		t.Error("files with expired lease have not been removed")
+	}
+}
+
+func TestFilesTempFile(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFiles(t, 0)
+	dir, err := fs.TempDir("test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temp := media.TempFile(dir + "/output")
+	if err := os.WriteFile(string(temp), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := fs.Buffer("text/plain", syncf.Val[flu.Input]{V: temp})
+	meta, err := ref.GetMeta(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Size != 4 || fs.usage != 4 {
+		t.Errorf("expected size and usage to be 4, got %d and %d", meta.Size, fs.usage)
+	}
+
+	if _, err := os.Stat(string(temp)); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be moved, got %v", err)
+	}
+}
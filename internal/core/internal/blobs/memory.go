@@ -0,0 +1,91 @@
+package blobs
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/pkg/errors"
+)
+
+// Memory keeps blobs in memory.
+// It is suitable only for small files since every blob is held in RAM until it is no longer referenced.
+type Memory struct {
+	SizeBounds [2]media.Size
+}
+
+func (m *Memory) String() string {
+	return ServiceID
+}
+
+func (m *Memory) Buffer(mimeType string, ref media.Ref) media.MetaRef {
+	return &memoryRef{
+		m:    m,
+		meta: media.Meta{MIMEType: mimeType},
+		ref:  ref,
+	}
+}
+
+// Acquire is a no-op since memory blobs are released by garbage collector.
+func (m *Memory) Acquire(ctx context.Context, input flu.Input) (release func()) {
+	return func() {}
+}
+
+type memoryRef struct {
+	m    *Memory
+	meta media.Meta
+	ref  media.Ref
+	data flu.Bytes
+	err  error
+	once sync.Once
+}
+
+func (r *memoryRef) GetMeta(ctx context.Context) (*media.Meta, error) {
+	r.once.Do(func() { r.get(ctx) })
+	return &r.meta, r.err
+}
+
+func (r *memoryRef) Get(ctx context.Context) (flu.Input, error) {
+	r.once.Do(func() { r.get(ctx) })
+	return r.data, r.err
+}
+
+func (r *memoryRef) get(ctx context.Context) {
+	input, err := r.ref.Get(ctx)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	data, ok := input.(flu.Bytes)
+	if !ok {
+		reader, err := input.Reader()
+		if err != nil {
+			r.err = errors.Wrap(err, "open input")
+			return
+		}
+
+		defer flu.CloseQuietly(reader)
+		if !skipSizeCheck(ctx) {
+			// read one extra byte so that too large blobs are detected without reading them fully
+			reader = io.LimitReader(reader, int64(r.m.SizeBounds[1])+1)
+		}
+
+		if data, err = io.ReadAll(reader); err != nil {
+			r.err = errors.Wrap(err, "read input")
+			return
+		}
+	}
+
+	size := media.Size(len(data))
+	if err := checkSize(ctx, r.m.SizeBounds, size); err != nil {
+		r.err = err
+		return
+	}
+
+	r.data = data
+	r.meta.Size = size
+}
@@ -0,0 +1,46 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+func TestMemory(t *testing.T) {
+	m := &Memory{SizeBounds: [2]media.Size{2, 10}}
+	for _, tc := range []struct {
+		name  string
+		ctx   context.Context
+		input flu.Input
+		size  media.Size
+		err   error
+	}{
+		{name: "bytes", ctx: context.Background(), input: flu.Bytes("hello"), size: 5},
+		{name: "reader", ctx: context.Background(), input: flu.IO{R: strings.NewReader("hello")}, size: 5},
+		{name: "too small", ctx: context.Background(), input: flu.Bytes("h")},
+		{name: "too large", ctx: context.Background(), input: flu.IO{R: bytes.NewReader(make([]byte, 100))}, err: ErrTooLarge},
+		{name: "skip size check", ctx: SkipSizeCheck(context.Background()), input: flu.IO{R: bytes.NewReader(make([]byte, 100))}, size: 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := m.Buffer("text/plain", syncf.Val[flu.Input]{V: tc.input})
+			meta, err := ref.GetMeta(tc.ctx)
+			switch {
+			case tc.size == 0 && err == nil:
+				t.Fatal("expected error")
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			case tc.size > 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.size > 0 && (meta.Size != tc.size || meta.MIMEType != "text/plain"):
+				t.Errorf("unexpected meta: %+v", meta)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package blobs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gofrs/uuid"
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+// S3 stores blobs in S3-compatible object storage.
+// Objects which have not been used for TTL are removed by the background janitor
+// (it is also advisable to configure bucket lifecycle rules in case the process is terminated abruptly).
+type S3 struct {
+	Clock         syncf.Clock
+	Client        s3iface.S3API
+	Bucket        string
+	Prefix        string
+	TTL           time.Duration
+	SizeBounds    [2]media.Size
+	CleanInterval time.Duration
+	Metrics       me3x.Registry
+
+	objects map[string]*s3Entry
+	usage   int64
+	once    sync.Once
+	mu      syncf.RWMutex
+	cancel  func()
+	work    syncf.WaitGroup
+}
+
+type s3Entry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+func (s *S3) String() string {
+	return ServiceID
+}
+
+func (s *S3) init() {
+	s.objects = make(map[string]*s3Entry)
+	if s.CleanInterval > 0 {
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		_, _ = syncf.GoWith(ctx, s.work.Spawn, s.runJanitor)
+	}
+}
+
+func (s *S3) Buffer(mimeType string, ref media.Ref) media.MetaRef {
+	s.once.Do(s.init)
+	return &s3Ref{
+		s:    s,
+		meta: media.Meta{MIMEType: mimeType},
+		ref:  ref,
+	}
+}
+
+// Acquire postpones object expiration until TTL passes after the reference is released.
+func (s *S3) Acquire(ctx context.Context, input flu.Input) (release func()) {
+	object, ok := input.(*S3Object)
+	if !ok || object.s != s {
+		return func() {}
+	}
+
+	s.touch(ctx, object.key)
+	var once sync.Once
+	return func() { once.Do(func() { s.touch(context.Background(), object.key) }) }
+}
+
+func (s *S3) upload(ctx context.Context, mimeType string, input flu.Input) (*S3Object, int64, error) {
+	reader, err := input.Reader()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "open input")
+	}
+
+	defer flu.CloseQuietly(reader)
+	counter := &s3Counter{Reader: reader}
+	if !skipSizeCheck(ctx) {
+		counter.limit = int64(s.SizeBounds[1])
+	}
+
+	key := s.Prefix + uuid.Must(uuid.NewV4()).String()
+	upload := &s3manager.UploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   counter,
+	}
+
+	if mimeType != "" {
+		upload.ContentType = aws.String(mimeType)
+	}
+
+	// register the object before uploading so that partially uploaded objects are removed on failure
+	if err := s.register(ctx, key); err != nil {
+		return nil, 0, err
+	}
+
+	_, err = s3manager.NewUploaderWithClient(s.Client).UploadWithContext(ctx, upload)
+	if counter.exceeded {
+		err = errors.Wrapf(ErrTooLarge, "size exceeds %s", s.SizeBounds[1])
+	}
+
+	logf.Get(s).Resultf(ctx, logf.Debug, logf.Warn, "upload blob object [%s] (%s): %v", key, media.Size(counter.n), err)
+	if err != nil {
+		s.discard(ctx, key)
+		return nil, 0, err
+	}
+
+	ctx, cancel := s.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+
+	defer cancel()
+	entry, ok := s.objects[key]
+	if !ok {
+		return nil, 0, errors.New("blob object has been evicted")
+	}
+
+	s.usage += counter.n
+	entry.size = counter.n
+	s.updateMetrics()
+	return &S3Object{s: s, key: key}, counter.n, nil
+}
+
+func (s *S3) register(ctx context.Context, key string) error {
+	ctx, cancel := s.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	defer cancel()
+	s.objects[key] = &s3Entry{lastUsed: s.Clock.Now()}
+	return nil
+}
+
+func (s *S3) touch(ctx context.Context, key string) {
+	ctx, cancel := s.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	if entry, ok := s.objects[key]; ok {
+		entry.lastUsed = s.Clock.Now()
+	}
+}
+
+func (s *S3) discard(ctx context.Context, key string) {
+	ctx, cancel := s.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	s.remove(ctx, key, "")
+}
+
+func (s *S3) remove(ctx context.Context, key string, reason string) {
+	if entry, ok := s.objects[key]; ok {
+		s.usage -= entry.size
+		delete(s.objects, key)
+	}
+
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+
+	logf.Get(s).Resultf(ctx, logf.Debug, logf.Warn, "remove blob object [%s]: %v", key, err)
+	if reason != "" {
+		s.Metrics.Counter("evicted", make(me3x.Labels, 0, 1).Add("reason", reason)).Inc()
+	}
+}
+
+func (s *S3) updateMetrics() {
+	s.Metrics.Gauge("usage_bytes", nil).Set(float64(s.usage))
+	s.Metrics.Gauge("files", nil).Set(float64(len(s.objects)))
+}
+
+func (s *S3) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(s.CleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.clean(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *S3) clean(ctx context.Context) {
+	ctx, cancel := s.mu.Lock(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	defer cancel()
+	now := s.Clock.Now()
+	for key, entry := range s.objects {
+		if now.Sub(entry.lastUsed) > s.TTL {
+			s.remove(ctx, key, "ttl")
+		}
+	}
+
+	s.updateMetrics()
+}
+
+func (s *S3) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+		s.work.Wait()
+	}
+
+	ctx, cancel := s.mu.Lock(context.Background())
+	defer cancel()
+	for key := range s.objects {
+		s.remove(ctx, key, "")
+	}
+
+	return nil
+}
+
+// S3Object is a blob stored in S3-compatible object storage.
+type S3Object struct {
+	s   *S3
+	key string
+}
+
+func (o *S3Object) String() string {
+	return "s3://" + o.s.Bucket + "/" + o.key
+}
+
+func (o *S3Object) Reader() (io.Reader, error) {
+	o.s.touch(context.Background(), o.key)
+	output, err := o.s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(o.s.Bucket),
+		Key:    aws.String(o.key),
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "get object")
+	}
+
+	return output.Body, nil
+}
+
+// URL returns a presigned URL of the object which is valid for TTL.
+func (o *S3Object) URL() (string, error) {
+	request, _ := o.s.Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(o.s.Bucket),
+		Key:    aws.String(o.key),
+	})
+
+	return request.Presign(o.s.TTL)
+}
+
+type s3Counter struct {
+	io.Reader
+	n        int64
+	limit    int64
+	exceeded bool
+}
+
+func (c *s3Counter) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	if c.limit > 0 && c.n >= c.limit {
+		c.exceeded = true
+		return n, ErrTooLarge
+	}
+
+	return n, err
+}
+
+type s3Ref struct {
+	s      *S3
+	meta   media.Meta
+	ref    media.Ref
+	object *S3Object
+	err    error
+	once   sync.Once
+}
+
+func (r *s3Ref) GetMeta(ctx context.Context) (*media.Meta, error) {
+	r.once.Do(func() { r.get(ctx) })
+	return &r.meta, r.err
+}
+
+func (r *s3Ref) Get(ctx context.Context) (flu.Input, error) {
+	r.once.Do(func() { r.get(ctx) })
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return r.object, nil
+}
+
+func (r *s3Ref) get(ctx context.Context) {
+	input, err := r.ref.Get(ctx)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	object, ok := input.(*S3Object)
+	if !ok || object.s != r.s {
+		var size int64
+		object, size, err = r.s.upload(ctx, r.meta.MIMEType, input)
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		if err := checkSize(ctx, r.s.SizeBounds, media.Size(size)); err != nil {
+			r.s.discard(ctx, object.key)
+			r.err = err
+			return
+		}
+
+		r.meta.Size = media.Size(size)
+	}
+
+	r.object = object
+}
@@ -0,0 +1,172 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+// testS3Server is a minimal stand-in for S3-compatible object storage with path-style addressing.
+type testS3Server struct {
+	objects map[string][]byte
+	puts    int
+	mu      sync.Mutex
+}
+
+func (s *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		s.puts++
+		s.objects[r.URL.Path] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3(t *testing.T) (*S3, *testS3Server) {
+	t.Helper()
+	server := &testS3Server{objects: make(map[string][]byte)}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(httpServer.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	s := &S3{
+		Clock:      syncf.DefaultClock,
+		Client:     s3.New(sess),
+		Bucket:     "bucket",
+		Prefix:     "blobs/",
+		TTL:        time.Hour,
+		SizeBounds: [2]media.Size{1, 100},
+		Metrics:    me3x.DummyRegistry{},
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+	return s, server
+}
+
+func TestS3Upload(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestS3(t)
+	data := []byte("hello, world")
+	ref := s.Buffer("text/plain", syncf.Val[flu.Input]{V: flu.Bytes(data)})
+	meta, err := ref.GetMeta(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if meta.Size != media.Size(len(data)) || meta.MIMEType != "text/plain" {
+		t.Errorf("unexpected meta: %+v", meta)
+	}
+
+	input, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object, ok := input.(*S3Object)
+	if !ok || !strings.HasPrefix(object.String(), "s3://bucket/blobs/") {
+		t.Fatalf("unexpected input: %v", input)
+	}
+
+	var buf bytes.Buffer
+	if _, err := flu.Copy(object, flu.IO{W: &buf}); err != nil {
+		t.Fatalf("read object: %v", err)
+	}
+
+	if buf.String() != string(data) {
+		t.Errorf("expected %q, got %q", data, buf.String())
+	}
+
+	if server.puts != 1 {
+		t.Errorf("expected a single upload, got %d", server.puts)
+	}
+}
+
+func TestS3TooLarge(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestS3(t)
+	_, err := s.Buffer("", syncf.Val[flu.Input]{V: flu.Bytes(bytes.Repeat([]byte{1}, 200))}).Get(ctx)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected too large error, got %v", err)
+	}
+
+	if len(server.objects) != 0 || len(s.objects) != 0 {
+		t.Errorf("expected failed upload to be discarded, got %d stored and %d tracked objects",
+			len(server.objects), len(s.objects))
+	}
+
+	// size check may be skipped, e.g. for intermediate conversion results
+	if _, err := s.Buffer("", syncf.Val[flu.Input]{V: flu.Bytes(bytes.Repeat([]byte{1}, 200))}).Get(SkipSizeCheck(ctx)); err != nil {
+		t.Errorf("unexpected error with skipped size check: %v", err)
+	}
+}
+
+func TestS3ReuseObject(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestS3(t)
+	input, err := s.Buffer("", syncf.Val[flu.Input]{V: flu.Bytes("data")}).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reused, err := s.Buffer("", syncf.Val[flu.Input]{V: input}).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reused != input {
+		t.Errorf("expected object %s to be reused, got %s", input, reused)
+	}
+
+	if server.puts != 1 {
+		t.Errorf("expected a single upload, got %d", server.puts)
+	}
+}
@@ -14,4 +14,17 @@ var (
 		"PATTERN – pattern to match subscription error.\n" +
 		"CHAT_ID – target chat username or '.' to use this chat.",
 	)
+
+	errHash = errors.New("" +
+		"Usage: /hash URL\n\n" +
+		"URL – media URL to show stored hashes for.")
+
+	errForget = errors.New("" +
+		"Usage: /forget HASH\n\n" +
+		"HASH – media hash reference as shown by /hash or /collided.")
+
+	errCollided = errors.New("" +
+		"Usage: /collided [CHAT_ID] [LIMIT]\n\n" +
+		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.\n" +
+		"LIMIT – maximum number of media to list. Optional, 10 by default.")
 )
@@ -3,7 +3,9 @@ package iface
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 
@@ -19,22 +21,30 @@ import (
 )
 
 const (
-	suspend = "s"
-	resume  = "r"
-	delete  = "d"
+	suspend      = "s"
+	resume       = "r"
+	delete       = "d"
+	forget       = "f"
+	notDuplicate = "n"
 
 	fire     = "🔥"
 	stop     = "🛑"
 	bin      = "🗑"
 	thumbsUp = "👍"
+	twins    = "👯"
+
+	defaultCollidedLimit = 10
 )
 
 type Impl struct {
-	Telegram     telegram.Client
-	Poller       feed.Poller
-	Storage      feed.Storage
-	SupervisorID telegram.ID
-	Aliases      map[string]telegram.ID
+	Telegram         telegram.Client
+	Poller           feed.Poller
+	Storage          feed.Storage
+	MediaHashes      feed.MediaHashStorage
+	Mediator         feed.Mediator
+	SupervisorID     telegram.ID
+	Aliases          map[string]telegram.ID
+	DuplicateNotices bool
 }
 
 func (i *Impl) String() string {
@@ -170,6 +180,72 @@ func (i *Impl) Sub(ctx context.Context, client telegram.Client, cmd *telegram.Co
 	return i.Subscribe(ctx, client, cmd)
 }
 
+func (i *Impl) Hash(ctx context.Context, _ telegram.Client, cmd *telegram.Command) error {
+	if len(cmd.Args) == 0 {
+		return errHash
+	}
+
+	hashes, err := i.MediaHashes.GetMediaHashes(ctx, cmd.Args[0])
+	if err != nil {
+		return err
+	}
+
+	return i.writeMediaHashes(ctx, cmd, fmt.Sprintf("%d hashes", len(hashes)), hashes)
+}
+
+func (i *Impl) Forget(ctx context.Context, _ telegram.Client, cmd *telegram.Command) error {
+	if len(cmd.Args) == 0 {
+		return errForget
+	}
+
+	key, err := parseMediaHash(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	hash, err := i.MediaHashes.GetMediaHash(ctx, key.FeedID, key.Type, key.Value)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := i.MediaHashes.DeleteMediaHash(ctx, hash.FeedID, hash.Type, hash.Value)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return feed.ErrNotFound
+	}
+
+	return cmd.Reply(ctx, i.Telegram, thumbsUp)
+}
+
+func (i *Impl) Collided(ctx context.Context, _ telegram.Client, cmd *telegram.Command) error {
+	ctx, feedID, err := i.resolveFeedID(ctx, cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	limit := defaultCollidedLimit
+	if len(cmd.Args) > 1 {
+		if limit, err = strconv.Atoi(cmd.Args[1]); err != nil || limit <= 0 {
+			return errCollided
+		}
+	}
+
+	hashes, err := i.MediaHashes.GetMostCollidedMediaHashes(ctx, feedID, limit)
+	if err != nil {
+		return err
+	}
+
+	chatTitle, err := i.getChatTitle(ctx, feedID)
+	if err != nil {
+		return err
+	}
+
+	return i.writeMediaHashes(ctx, cmd, fmt.Sprintf("%d most collided @ %s", len(hashes), chatTitle), hashes)
+}
+
 //
 // Callback aliases
 //
@@ -198,6 +274,42 @@ func (i *Impl) D_callback(ctx context.Context, client telegram.Client, cmd *tele
 	return cmd.ReplyCallback(ctx, client, thumbsUp)
 }
 
+func (i *Impl) F_callback(ctx context.Context, client telegram.Client, cmd *telegram.Command) error {
+	return i.Forget(ctx, client, cmd)
+}
+
+// N_callback marks media dropped as duplicate as unique and sends the media to the chat
+// with options it has been mediated with.
+// The media hash is kept, so that the media still takes part in deduplication within its scope.
+func (i *Impl) N_callback(ctx context.Context, client telegram.Client, cmd *telegram.Command) error {
+	key, err := parseMediaHash(cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	hash, err := i.MediaHashes.GetMediaHash(ctx, key.FeedID, key.Type, key.Value)
+	if err != nil {
+		return err
+	}
+
+	if _, err := i.MediaHashes.ResetMediaHash(ctx, hash.FeedID, hash.Type, hash.Value); err != nil {
+		return err
+	}
+
+	// deduplication is not requested since the media would be dropped as a duplicate of the matched one again
+	mediaRef := i.Mediator.Mediate(ctx, hash.URL, feed.MediaOptions{
+		Spoiler:  hash.Spoiler,
+		Document: hash.Document,
+	})
+	if err := ext.HTML(ctx, i.Telegram, telegram.ID(hash.FeedID)).
+		Media(hash.URL, mediaRef, true, true).
+		Flush(); err != nil {
+		return err
+	}
+
+	return cmd.ReplyCallback(ctx, client, thumbsUp)
+}
+
 //
 // After triggers
 //
@@ -265,10 +377,116 @@ func (i *Impl) AfterClear(ctx context.Context, feedID feed.ID, pattern string, d
 		Flush()
 }
 
+func (i *Impl) AfterDuplicate(ctx context.Context, hash *feed.MediaHash) error {
+	if !i.DuplicateNotices || i.SupervisorID == 0 {
+		return nil
+	}
+
+	chatTitle, err := i.getChatTitle(ctx, hash.FeedID)
+	if err != nil {
+		return err
+	}
+
+	buttons := []telegram.Button{
+		(&telegram.Command{Key: notDuplicate, Args: []string{formatMediaHashKey(hash)}}).Button("Not a duplicate"),
+	}
+
+	ctx = receiver.ReplyMarkup(ctx, telegram.InlineKeyboard(buttons))
+	html := ext.HTML(ctx, i.Telegram, i.SupervisorID).
+		Link("media", hash.URL).
+		Text(" @ ").
+		Text(chatTitle).
+		Text(" %s", twins)
+	if hash.MatchedURL.Valid {
+		html = html.Text("\n").Link("matched", hash.MatchedURL.String)
+	}
+
+	return html.Flush()
+}
+
 //
 // Implementation details
 //
 
+const mediaHashDelimiter = "+"
+
+func formatMediaHash(hash *feed.MediaHash) string {
+	return strings.Join([]string{hash.FeedID.String(), hash.Type, hash.Value}, mediaHashDelimiter)
+}
+
+// formatMediaHashKey formats a hash reference which fits into button callback data.
+func formatMediaHashKey(hash *feed.MediaHash) string {
+	key := *hash
+	if len(key.Value) > feed.MediaHashKeyLength {
+		key.Value = key.Value[:feed.MediaHashKeyLength]
+	}
+
+	return formatMediaHash(&key)
+}
+
+func parseMediaHash(cmd *telegram.Command, argumentIndex int) (*feed.MediaHash, error) {
+	arg := cmd.Args[argumentIndex]
+	tokens := strings.Split(arg, mediaHashDelimiter)
+	if len(tokens) != 3 {
+		return nil, errors.Errorf("invalid media hash [%s]", arg)
+	}
+
+	feedID, err := telegram.ParseID(tokens[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid feed id: %s", tokens[0])
+	}
+
+	if !isHex(tokens[2]) {
+		return nil, errors.Errorf("invalid hash value: %s", tokens[2])
+	}
+
+	return &feed.MediaHash{
+		FeedID: feed.ID(feedID),
+		Type:   tokens[1],
+		Value:  tokens[2],
+	}, nil
+}
+
+func isHex(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, r := range value {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeMediaHashes replies with media hash details and buttons for forgetting them.
+func (i *Impl) writeMediaHashes(ctx context.Context, cmd *telegram.Command, title string, hashes []feed.MediaHash) error {
+	keyboard := make([][]telegram.Button, len(hashes))
+	for j := range hashes {
+		keyboard[j] = []telegram.Button{
+			(&telegram.Command{Key: forget, Args: []string{formatMediaHashKey(&hashes[j])}}).Button(fmt.Sprintf("Forget #%d", j+1)),
+		}
+	}
+
+	ctx = receiver.ReplyMarkup(ctx, telegram.InlineKeyboard(keyboard...))
+	html := ext.HTML(ctx, i.Telegram, cmd.Chat.ID).Text(title)
+	for j, hash := range hashes {
+		html = html.Text("\n\n#%d ", j+1).
+			Link("media", hash.URL).
+			Text(" @ %d\n", hash.FeedID).
+			Code(formatMediaHash(&hash)).
+			Text("\n%d collisions, first seen %s, last seen %s",
+				hash.Collisions, hash.FirstSeen.Format(time.DateTime), hash.LastSeen.Format(time.DateTime))
+		if hash.MatchedURL.Valid {
+			html = html.Text("\nmatched ").Link("media", hash.MatchedURL.String)
+		}
+	}
+
+	return html.Flush()
+}
+
 const headerDelimiter = "+"
 
 func formatHeader(header feed.Header) string {
@@ -0,0 +1,58 @@
+package iface
+
+import (
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+func TestMediaHashKey(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hash feed.MediaHash
+		key  string
+	}{
+		{
+			name: "md5",
+			hash: feed.MediaHash{FeedID: -1001234567890, Type: "md5", Value: "d41d8cd98f00b204e9800998ecf8427e"},
+			key:  "-1001234567890+md5+d41d8cd98f00b204e9800998ecf8427e",
+		},
+		{
+			name: "video hash",
+			hash: feed.MediaHash{FeedID: -1001234567890, Type: "vdhash64",
+				Value: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+			key: "-1001234567890+vdhash64+0123456789abcdef0123456789abcdef",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key := formatMediaHashKey(&tc.hash)
+			if key != tc.key {
+				t.Errorf("expected key %s, got %s", tc.key, key)
+			}
+
+			// callback data is limited to 64 bytes
+			if data := notDuplicate + " " + key; len(data) > 64 {
+				t.Errorf("callback data is too long: %d bytes", len(data))
+			}
+
+			parsed, err := parseMediaHash(&telegram.Command{Args: []string{key}}, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if parsed.FeedID != tc.hash.FeedID || parsed.Type != tc.hash.Type || len(parsed.Value) > feed.MediaHashKeyLength {
+				t.Errorf("unexpected parsed key: %+v", parsed)
+			}
+		})
+	}
+}
+
+func TestParseMediaHashInvalid(t *testing.T) {
+	for _, arg := range []string{"1+dhash", "x+dhash+abc", "1+dhash+ab%", "1+dhash+"} {
+		if _, err := parseMediaHash(&telegram.Command{Args: []string{arg}}, 0); err == nil {
+			t.Errorf("expected error for %s", arg)
+		}
+	}
+}
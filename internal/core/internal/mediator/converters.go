@@ -0,0 +1,113 @@
+package mediator
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
+)
+
+func (m *Impl) RegisterMediaConverter(converter media.Converter) {
+	if m.converters == nil {
+		m.converters = make(map[media.Conversion][]media.Converter)
+	}
+
+	for _, conversion := range converter.Conversions() {
+		m.converters[conversion] = append(m.converters[conversion], converter)
+	}
+}
+
+// getConverters returns converters which declare support for the conversion.
+// Converters supporting the exact source type are tried first.
+func (m *Impl) getConverters(conversion media.Conversion) []media.Converter {
+	converters := m.converters[conversion]
+	if conversion.From != media.AnyType {
+		converters = append(converters[:len(converters):len(converters)],
+			m.converters[media.Conversion{From: media.AnyType, To: conversion.To}]...)
+	}
+
+	return converters
+}
+
+// getTargetType returns MIME type the media should be converted to
+// or an empty string if media does not need to be converted.
+// Conversions configured for media variant (like animated GIF) take precedence.
+// The variant is returned along with the target type.
+func (m *Impl) getTargetType(ctx context.Context, ref media.Ref, mimeType string) (string, string, error) {
+	variant, err := getVariantType(ctx, ref, mimeType)
+	if err != nil {
+		return "", "", err
+	}
+
+	if targetType, ok := m.Conversions[variant]; ok {
+		return variant, targetType, nil
+	}
+
+	return variant, m.Conversions[mimeType], nil
+}
+
+// convert converts media according to configured conversions until no more conversions are applicable.
+// Media is left as is if there are no converters capable of the conversion or all of them fail.
+// It also reports if the media has been converted from an animated image.
+func (m *Impl) convert(ctx context.Context, meta *media.Meta, ref media.Ref) (*media.Meta, media.Ref, bool, error) {
+	var (
+		visited  = make(map[string]bool)
+		animated bool
+	)
+
+	for !visited[meta.MIMEType] {
+		visited[meta.MIMEType] = true
+		variant, targetType, err := m.getTargetType(ctx, ref, meta.MIMEType)
+		if err != nil {
+			return nil, nil, false, errors.Wrap(err, "get target type")
+		}
+
+		if targetType == "" || targetType == meta.MIMEType {
+			break
+		}
+
+		conversion := media.Conversion{From: meta.MIMEType, To: targetType}
+		metaRef, err := m.tryConvert(ctx, ref, conversion)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		if metaRef == nil {
+			break
+		}
+
+		if meta, err = metaRef.GetMeta(ctx); err != nil {
+			return nil, nil, false, errors.Wrap(err, "get meta")
+		}
+
+		ref = m.bufferLeaveURL(meta.MIMEType, metaRef)
+		animated = animated || strings.HasSuffix(variant, animatedVariant)
+	}
+
+	return meta, ref, animated, nil
+}
+
+func (m *Impl) tryConvert(ctx context.Context, ref media.Ref, conversion media.Conversion) (media.MetaRef, error) {
+	converters := m.getConverters(conversion)
+	if len(converters) == 0 {
+		logf.Get(m).Debugf(ctx, "no converters for [%s]", conversion)
+		return nil, nil
+	}
+
+	for _, converter := range converters {
+		metaRef, err := converter.Convert(ctx, ref, conversion)
+		if metaRef == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "convert [%s] with [%s]: %v", conversion, converter, err)
+		if metaRef != nil {
+			return metaRef, nil
+		}
+	}
+
+	return nil, nil
+}
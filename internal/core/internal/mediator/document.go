@@ -0,0 +1,116 @@
+package mediator
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+)
+
+// audioTypes are MIME types which are sent as audio files in addition to the ones known to telegram-bot-api.
+var audioTypes = map[string]bool{
+	"audio/mp4":   true,
+	"audio/x-m4a": true,
+}
+
+// nonMediaTypes are MIME types of web resources which are never sent as documents.
+var nonMediaTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"application/javascript": true,
+}
+
+// remoteDocumentTypes are document MIME types which Telegram is able to download by URL.
+var remoteDocumentTypes = map[string]bool{
+	"application/pdf": true,
+	"application/zip": true,
+}
+
+// animationFilename is the filename of videos converted from animated images.
+// telegram-bot-api would use .gif extension for animations otherwise.
+const animationFilename = "animation.mp4"
+
+// getMediaType returns Telegram media type for the MIME type.
+// Videos converted from animated images have animated variant MIME type and are sent as animations.
+func getMediaType(mimeType string) telegram.MediaType {
+	switch {
+	case audioTypes[mimeType]:
+		return telegram.Audio
+	case strings.HasSuffix(mimeType, animatedVariant):
+		return telegram.Animation
+	}
+
+	return telegram.MediaTypeByMIMEType(mimeType)
+}
+
+// isDocumentType checks if media of the MIME type may be sent as a document.
+func isDocumentType(mimeType string) bool {
+	return mimeType != "" && !strings.HasPrefix(mimeType, "text/") && !nonMediaTypes[mimeType]
+}
+
+// canSendURL checks if Telegram is able to download the media by URL.
+func canSendURL(mimeType string, mediaType telegram.MediaType) bool {
+	return mediaType != telegram.Document || remoteDocumentTypes[mimeType]
+}
+
+// getFilename returns the original filename of the media.
+// The last source URL path segment is used if it looks like a filename.
+func getFilename(source *url.URL, meta *media.Meta) string {
+	if meta.Filename != "" {
+		return meta.Filename
+	}
+
+	if name := path.Base(source.Path); path.Ext(name) != "" {
+		return name
+	}
+
+	return ""
+}
+
+// probeAudio probes audio attributes with registered probers.
+// Attributes are optional, so errors are only logged.
+// Media sent by URL are not probed since they are downloaded by Telegram.
+func (m *Impl) probeAudio(ctx context.Context, file *fileRef, media *receiver.Media) {
+	if _, ok := media.Input.(flu.URL); ok {
+		return
+	}
+
+	ref := syncf.Val[flu.Input]{V: media.Input}
+	for _, prober := range m.audioProbers {
+		audio, err := prober.ProbeAudio(ctx, ref, media.MIMEType)
+		if audio == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "probe audio [%s] with [%s]: %v", file.source, prober, err)
+		if audio != nil {
+			file.audio = audio
+			return
+		}
+	}
+}
+
+// setAudioParams sets sendAudio parameters which are not supported by telegram-bot-api.
+func setAudioParams(params map[string]string, audio *media.AudioMeta) {
+	if audio.Duration > 0 {
+		params["duration"] = strconv.Itoa(int(audio.Duration.Seconds()))
+	}
+
+	if audio.Title != "" {
+		params["title"] = audio.Title
+	}
+
+	if audio.Performer != "" {
+		params["performer"] = audio.Performer
+	}
+}
@@ -0,0 +1,87 @@
+package mediator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+	"github.com/pkg/errors"
+)
+
+// testAudioProber counts probe calls and returns fixed audio attributes.
+type testAudioProber struct {
+	calls int
+}
+
+func (p *testAudioProber) String() string {
+	return "test"
+}
+
+func (p *testAudioProber) ProbeAudio(ctx context.Context, ref media.Ref, mimeType string) (*media.AudioMeta, error) {
+	p.calls++
+	return &media.AudioMeta{Duration: time.Minute}, nil
+}
+
+func TestProbeAudio(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		input  flu.Input
+		probed bool
+	}{
+		{
+			name:  "url",
+			input: flu.URL("http://127.0.0.1/audio.mp3"),
+		},
+		{
+			name:   "local",
+			input:  flu.Bytes("audio"),
+			probed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prober := new(testAudioProber)
+			m := &Impl{audioProbers: []media.AudioProber{prober}}
+			file := new(fileRef)
+			m.probeAudio(context.Background(), file, &receiver.Media{MIMEType: "audio/mpeg", Input: tc.input})
+			switch {
+			case tc.probed && (prober.calls != 1 || file.audio == nil):
+				t.Errorf("expected audio to be probed, got %d calls and %v", prober.calls, file.audio)
+			case !tc.probed && (prober.calls != 0 || file.audio != nil):
+				t.Errorf("expected audio not to be probed, got %d calls and %v", prober.calls, file.audio)
+			}
+		})
+	}
+}
+
+// failSender fails on any call so that raw sends are checked to not fall back to it.
+type failSender struct{}
+
+func (failSender) Send(ctx context.Context, chatID telegram.ChatID, sendable telegram.Sendable, options *telegram.SendOptions) (*telegram.Message, error) {
+	return nil, errors.New("unexpected send")
+}
+
+func TestFileSenderRawFloodControl(t *testing.T) {
+	exec := new(floodExecutor)
+	sender := &fileSender{Sender: failSender{}, raw: getRawSender(exec)}
+	for i, mediaType := range []telegram.MediaType{telegram.Document, telegram.Audio, telegram.Voice} {
+		payload := &telegram.Media{Type: mediaType, Input: flu.Bytes("data")}
+		if _, err := sender.send(context.Background(), telegram.ID(i+1), new(fileRef), payload, nil); err != nil {
+			t.Fatalf("send %s: %v", mediaType, err)
+		}
+	}
+
+	if len(exec.calls) != 3 {
+		t.Fatalf("expected 3 raw calls, got %d", len(exec.calls))
+	}
+
+	for i := 1; i < len(exec.calls); i++ {
+		if gap := exec.gap(i); gap < telegram.GatewaySendDelay {
+			t.Errorf("expected gateway delay before call %d, got %s", i, gap)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package mediator
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+)
+
+// fileRef is a media reference which allows to reuse Telegram file IDs of uploaded media.
+type fileRef struct {
+	receiver.MediaRef
+	m        *Impl
+	source   *url.URL
+	resolved media.MetaRef
+	dedup    *dedupOpts
+	noCache  bool
+	spoiler  bool
+	document bool
+	hash     *feed.MediaHash
+	cached   *feed.MediaFile
+	mimeType string
+	filename string
+	// animation is set if the media has been converted from an animated image
+	animation bool
+	audio     *media.AudioMeta
+	video     *media.VideoMeta
+	release   func()
+}
+
+// done releases the blob file backing the media so that it may be evicted.
+func (r *fileRef) done() {
+	if r.release != nil {
+		r.release()
+	}
+}
+
+// videoMeta returns video attributes to be sent along with the media.
+// Media which have already been uploaded to Telegram are sent without them.
+func (r *fileRef) videoMeta(mediaType telegram.MediaType) *media.VideoMeta {
+	if mediaType != telegram.Video || r.cached != nil {
+		return nil
+	}
+
+	return r.video
+}
+
+// save saves the file ID of the uploaded media.
+// File IDs of images sent as documents on request are not saved, since they would be reused for all subscriptions.
+func (r *fileRef) save(ctx context.Context, message *mediaMessage) {
+	fileID := getFileID(message)
+	if fileID == "" || r.document || r.cached != nil && r.cached.URL == r.source.String() {
+		return
+	}
+
+	file := &feed.MediaFile{
+		URL:       r.source.String(),
+		FileID:    fileID,
+		MIMEType:  r.mimeType,
+		CreatedAt: r.m.Clock.Now(),
+	}
+
+	if r.hash != nil {
+		file.HashType = null.StringFrom(r.hash.Type)
+		file.Hash = null.StringFrom(r.hash.Value)
+	}
+
+	err := r.m.Files.SaveMediaFile(ctx, file)
+	logf.Get(r.m).Resultf(ctx, logf.Debug, logf.Warn, "save media file [%s => %s]: %v", r.source, fileID, err)
+}
+
+// invalidate deletes the cached file ID rejected by Telegram and mediates the media again.
+func (r *fileRef) invalidate(ctx context.Context) (*fileRef, *receiver.Media, error) {
+	deleted, err := r.m.Files.DeleteMediaFiles(ctx, r.cached.FileID)
+	logf.Get(r.m).Resultf(ctx, logf.Debug, logf.Warn, "delete %d media files [%s]: %v", deleted, r.cached.FileID, err)
+
+	labels := make(me3x.Labels, 0, 1).
+		Add("origin", r.source.Host)
+	r.m.Metrics.Counter("file_id_rejected", labels).Inc()
+
+	file := &fileRef{
+		m:        r.m,
+		source:   r.source,
+		resolved: r.resolved,
+		noCache:  true,
+		spoiler:  r.spoiler,
+		document: r.document,
+		hash:     r.hash,
+		video:    r.video,
+	}
+
+	media, err := r.m.run(ctx, file)
+	if err == nil && media == nil {
+		err = errors.New("no media")
+	}
+
+	return file, media, err
+}
+
+// getMediaFile looks up a media file which has already been uploaded to Telegram.
+// If media deduplication is required and the media has not been hashed yet,
+// the hash saved along with the media file is used.
+func (m *Impl) getMediaFile(ctx context.Context, file *fileRef) (*receiver.Media, error) {
+	if file.noCache || file.document {
+		return nil, nil
+	}
+
+	cached, err := m.Files.GetMediaFile(ctx, file.source.String(), file.hash)
+	if err != nil {
+		logf.Get(m).Warnf(ctx, "get media file [%s]: %v", file.source, err)
+		return nil, nil
+	}
+
+	if cached == nil {
+		return nil, nil
+	}
+
+	if file.dedup != nil && file.hash == nil {
+		if !cached.HashType.Valid || !cached.Hash.Valid {
+			return nil, nil
+		}
+
+		hash := m.newMediaHash(file.dedup)
+		if err := restoreHash(hash, cached.HashType.String, cached.Hash.String); err != nil {
+			logf.Get(m).Warnf(ctx, "restore hash for [%s]: %v", file.source, err)
+			return nil, nil
+		}
+
+		file.hash = hash
+		if err := m.checkUnique(ctx, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	file.cached = cached
+	labels := make(me3x.Labels, 0, 1).
+		Add("origin", file.source.Host)
+	m.Metrics.Counter("file_id", labels).Inc()
+	logf.Get(m).Debugf(ctx, "found media file for [%s]: %s", file.source, cached.FileID)
+	return &receiver.Media{
+		MIMEType: cached.MIMEType,
+		Input:    flu.URL(cached.FileID),
+	}, nil
+}
+
+// mediaMessage is a message with media fields which are not supported by telegram-bot-api.
+type mediaMessage struct {
+	telegram.Message
+	Document *telegram.MessageFile `json:"document"`
+	Audio    *telegram.MessageFile `json:"audio"`
+	Voice    *telegram.MessageFile `json:"voice"`
+}
+
+func getFileID(message *mediaMessage) string {
+	switch {
+	case message == nil:
+		return ""
+	case len(message.Photo) > 0:
+		return message.Photo[len(message.Photo)-1].ID
+	case message.Video != nil:
+		return message.Video.ID
+	case message.Animation != nil:
+		return message.Animation.ID
+	case message.Audio != nil:
+		return message.Audio.ID
+	case message.Voice != nil:
+		return message.Voice.ID
+	case message.Document != nil:
+		return message.Document.ID
+	default:
+		return ""
+	}
+}
+
+// Chat wraps chat receiver so that file IDs of media uploaded to Telegram are saved for reuse.
+// Media groups are sent as such if chat sender supports them.
+func Chat(chat *receiver.Chat) receiver.Interface {
+	groups, _ := chat.Sender.(mediaGroupSender)
+	var raw *rawSender
+	if exec, ok := chat.Sender.(executor); ok {
+		raw = getRawSender(exec)
+	}
+
+	chat.Sender = &fileSender{Sender: chat.Sender, raw: raw}
+	return &fileReceiver{Chat: chat, groups: groups, raw: raw}
+}
+
+type fileRefKey struct{}
+
+type fileReceiver struct {
+	*receiver.Chat
+	groups mediaGroupSender
+	raw    *rawSender
+}
+
+func (r *fileReceiver) SendMedia(ctx context.Context, ref receiver.MediaRef, caption string) error {
+	if group, ok := ref.(*groupRef); ok {
+		return r.sendGroup(ctx, group, caption)
+	}
+
+	if file, ok := ref.(*fileRef); ok {
+		ctx = context.WithValue(ctx, fileRefKey{}, file)
+	}
+
+	return r.Chat.SendMedia(ctx, ref, caption)
+}
+
+type fileSender struct {
+	telegram.Sender
+	raw *rawSender
+}
+
+func (s *fileSender) Send(ctx context.Context, chatID telegram.ChatID, sendable telegram.Sendable, options *telegram.SendOptions) (*telegram.Message, error) {
+	file, ok := ctx.Value(fileRefKey{}).(*fileRef)
+	payload, isMedia := sendable.(*telegram.Media)
+	if !ok || !isMedia {
+		return s.Sender.Send(ctx, chatID, sendable, options)
+	}
+
+	payload.Type = getMediaType(file.mimeType)
+	message, err := s.send(ctx, chatID, file, payload, options)
+	defer file.done()
+	if file.cached != nil && isBadRequest(err) {
+		logf.Get(file.m).Warnf(ctx, "file ID for [%s] has been rejected: %v", file.source, err)
+		var media *receiver.Media
+		file, media, err = file.invalidate(ctx)
+		defer file.done()
+		if err != nil {
+			return nil, err
+		}
+
+		payload.Type = getMediaType(media.MIMEType)
+		payload.Input = media.Input
+		message, err = s.send(ctx, chatID, file, payload, options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.save(ctx, message)
+	return &message.Message, nil
+}
+
+// send sends the media covering it with a spoiler if requested.
+// Documents, audio files and voice messages are sent via raw calls so that their file IDs are available.
+// Videos are sent via raw calls along with their attributes and thumbnail.
+func (s *fileSender) send(ctx context.Context, chatID telegram.ChatID, file *fileRef, payload *telegram.Media, options *telegram.SendOptions) (*mediaMessage, error) {
+	if payload.Filename == "" {
+		payload.Filename = file.filename
+	}
+
+	params := make(map[string]string)
+	if file.spoiler && canSpoiler(payload.Type) {
+		params["has_spoiler"] = "true"
+	}
+
+	if payload.Type == telegram.Audio && file.audio != nil {
+		setAudioParams(params, file.audio)
+	}
+
+	var thumbnail flu.Input
+	if video := file.videoMeta(payload.Type); video != nil {
+		setVideoParams(params, video)
+		thumbnail = video.Thumbnail
+	}
+
+	switch {
+	case s.raw == nil:
+	case len(params) > 0, payload.Type == telegram.Document, payload.Type == telegram.Audio, payload.Type == telegram.Voice:
+		return s.raw.send(ctx, chatID, payload, options, params, thumbnail)
+	}
+
+	message, err := s.Sender.Send(ctx, chatID, payload, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mediaMessage{Message: *message}, nil
+}
+
+func isBadRequest(err error) bool {
+	var tgErr telegram.Error
+	return errors.As(err, &tgErr) && tgErr.ErrorCode == http.StatusBadRequest
+}
@@ -0,0 +1,109 @@
+package mediator
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// rawSender executes raw Telegram Bot API send calls.
+// Calls are delayed per bot and per chat the same way telegram-bot-api delays its own send calls,
+// so that raw calls do not exceed Telegram flood limits.
+// telegram-bot-api does not expose its flood control state, so delays are tracked separately.
+type rawSender struct {
+	exec    executor
+	clock   syncf.Clock
+	gateway syncf.Locker
+	chats   map[telegram.ChatID]syncf.Locker
+	mu      sync.RWMutex
+}
+
+var (
+	rawSenders   = make(map[executor]*rawSender)
+	rawSendersMu sync.Mutex
+)
+
+// getRawSender returns the raw sender for the executor.
+// Flood control state is shared between all chats sending via the same executor.
+func getRawSender(exec executor) *rawSender {
+	rawSendersMu.Lock()
+	defer rawSendersMu.Unlock()
+	if sender, ok := rawSenders[exec]; ok {
+		return sender
+	}
+
+	sender := &rawSender{
+		exec:    exec,
+		clock:   syncf.DefaultClock,
+		gateway: syncf.Semaphore(syncf.DefaultClock, 1, telegram.GatewaySendDelay),
+	}
+
+	rawSenders[exec] = sender
+	return sender
+}
+
+// execute executes the API call respecting Telegram flood control.
+func (s *rawSender) execute(ctx context.Context, chatID telegram.ChatID, method string, body flu.EncoderTo, resp any) error {
+	if lock, ok := s.getLock(chatID); ok {
+		ctx, cancel := lock.Lock(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		defer cancel()
+	}
+
+	ctx, cancel := s.gateway.Lock(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	defer cancel()
+
+	for i := 0; ; i++ {
+		err := s.exec.Execute(ctx, method, body, resp)
+		var tooMany telegram.TooManyMessages
+		if !errors.As(err, &tooMany) || i >= telegram.MaxSendRetries {
+			return err
+		}
+
+		if err := flu.Sleep(ctx, tooMany.RetryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *rawSender) getLock(chatID telegram.ChatID) (syncf.Locker, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lock, ok := s.chats[chatID]
+	return lock, ok
+}
+
+// createLock creates a chat lock with delay depending on the chat type.
+// Chat types are not known before the first message is sent.
+func (s *rawSender) createLock(chat *telegram.Chat) {
+	if chat.ID == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chats[chat.ID]; ok {
+		return
+	}
+
+	if s.chats == nil {
+		s.chats = make(map[telegram.ChatID]syncf.Locker)
+	}
+
+	lock := syncf.Semaphore(s.clock, 1, chat.Type.SendDelay())
+	s.chats[chat.ID] = lock
+	if chat.Username != nil {
+		s.chats[*chat.Username] = lock
+	}
+}
@@ -0,0 +1,92 @@
+package mediator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+// floodExecutor records call times and replies with messages in the chat.
+type floodExecutor struct {
+	chat  telegram.Chat
+	errs  []error
+	calls []time.Time
+}
+
+func (e *floodExecutor) Execute(ctx context.Context, method string, body flu.EncoderTo, resp any) error {
+	e.calls = append(e.calls, time.Now())
+	if len(e.errs) > 0 {
+		err := e.errs[0]
+		e.errs = e.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+
+	if message, ok := resp.(*mediaMessage); ok {
+		message.Chat = e.chat
+	}
+
+	return nil
+}
+
+func (e *floodExecutor) gap(i int) time.Duration {
+	return e.calls[i].Sub(e.calls[i-1])
+}
+
+func TestRawSenderFloodControl(t *testing.T) {
+	const chatType telegram.ChatType = "test"
+	telegram.SendDelays[chatType] = 200 * time.Millisecond
+	t.Cleanup(func() { delete(telegram.SendDelays, chatType) })
+
+	ctx := context.Background()
+	payload := &telegram.Media{Type: telegram.Document, Input: flu.URL("https://example.com/file.pdf")}
+
+	t.Run("chat", func(t *testing.T) {
+		exec := &floodExecutor{chat: telegram.Chat{ID: 1, Type: chatType}}
+		sender := getRawSender(exec)
+		for i := 0; i < 3; i++ {
+			if _, err := sender.send(ctx, telegram.ID(1), payload, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// chat type is known after the first message
+		if gap := exec.gap(2); gap < telegram.SendDelays[chatType] {
+			t.Errorf("expected chat delay, got %s", gap)
+		}
+	})
+
+	t.Run("gateway", func(t *testing.T) {
+		exec := new(floodExecutor)
+		sender := getRawSender(exec)
+		for i := 0; i < 2; i++ {
+			if _, err := sender.send(ctx, telegram.ID(i+1), payload, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if gap := exec.gap(1); gap < telegram.GatewaySendDelay {
+			t.Errorf("expected gateway delay, got %s", gap)
+		}
+	})
+
+	t.Run("too many messages", func(t *testing.T) {
+		retryAfter := 100 * time.Millisecond
+		exec := &floodExecutor{errs: []error{telegram.TooManyMessages{RetryAfter: retryAfter}}}
+		if _, err := getRawSender(exec).send(ctx, telegram.ID(1), payload, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(exec.calls) != 2 {
+			t.Fatalf("expected 2 calls, got %d", len(exec.calls))
+		}
+
+		if gap := exec.gap(1); gap < retryAfter {
+			t.Errorf("expected retry after %s, got %s", retryAfter, gap)
+		}
+	})
+}
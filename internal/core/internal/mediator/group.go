@@ -0,0 +1,232 @@
+package mediator
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+)
+
+// MaxMediaGroupSize is the maximum number of media in a single Telegram media group.
+const MaxMediaGroupSize = 10
+
+func (m *Impl) MediateAll(ctx context.Context, source string, options feed.MediaOptions) feed.MediaGroupRef {
+	url, err := url.Parse(source)
+	if err != nil {
+		return &groupRef{items: syncf.Val[[]receiver.MediaRef]{E: err}}
+	}
+
+	m.once.Do(m.init)
+	group := &groupRef{m: m}
+	group.items = syncf.AsyncWith[[]receiver.MediaRef](m.ctx, m.work.Spawn, func(ctx context.Context) ([]receiver.MediaRef, error) {
+		ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		metaRefs := m.resolveGroup(ctx, url)
+		if metaRefs == nil {
+			return []receiver.MediaRef{m.spawn(url, nil, options)}, nil
+		}
+
+		refs := make([]receiver.MediaRef, len(metaRefs))
+		for i, metaRef := range metaRefs {
+			refs[i] = m.spawn(itemSource(url, i, metaRef), metaRef, options)
+		}
+
+		return refs, nil
+	})
+
+	return group
+}
+
+// itemSource returns URL used for caching Telegram file ID of a group item.
+func itemSource(source *url.URL, index int, metaRef media.MetaRef) *url.URL {
+	if ref, ok := metaRef.(*media.HTTPRef); ok {
+		if url, err := url.Parse(ref.URL); err == nil {
+			return url
+		}
+	}
+
+	item := *source
+	item.Fragment = strconv.Itoa(index + 1)
+	return &item
+}
+
+// groupRef is a reference to several media mediated from a single URL.
+type groupRef struct {
+	m     *Impl
+	items syncf.Ref[[]receiver.MediaRef]
+}
+
+func (r *groupRef) GetAll(ctx context.Context) ([]receiver.MediaRef, error) {
+	return r.items.Get(ctx)
+}
+
+func (r *groupRef) Get(ctx context.Context) (*receiver.Media, error) {
+	refs, err := r.GetAll(ctx)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+
+	for _, ref := range refs[1:] {
+		if file, ok := ref.(*fileRef); ok {
+			go file.discard()
+		}
+	}
+
+	return refs[0].Get(ctx)
+}
+
+// discard releases the media which is not going to be sent.
+func (r *fileRef) discard() {
+	_, _ = r.MediaRef.Get(r.m.ctx)
+	r.done()
+}
+
+type mediaGroupSender interface {
+	SendMediaGroup(ctx context.Context, chatID telegram.ChatID, media []telegram.Media, options *telegram.SendOptions) ([]telegram.Message, error)
+}
+
+// sendGroup sends group media as media groups.
+// Media which can not be grouped (like animations) are sent separately.
+func (r *fileReceiver) sendGroup(ctx context.Context, group *groupRef, caption string) error {
+	refs, err := group.GetAll(ctx)
+	if err != nil {
+		return r.Chat.SendMedia(ctx, receiver.MediaError{E: err}, caption)
+	}
+
+	if len(refs) == 1 || r.groups == nil {
+		for _, ref := range refs {
+			if err := r.SendMedia(ctx, ref, caption); err != nil {
+				return err
+			}
+
+			caption = ""
+		}
+
+		return nil
+	}
+
+	var (
+		files   = make([]*fileRef, 0, len(refs))
+		lastErr error
+	)
+
+	for _, ref := range refs {
+		file := ref.(*fileRef)
+		media, err := file.Get(ctx)
+		switch {
+		case err != nil:
+			logf.Get(r).Warnf(ctx, "skip group item [%s]: %v", file.source, err)
+			lastErr = err
+			continue
+		case media == nil:
+			continue
+		}
+
+		if mediaType := getMediaType(media.MIMEType); mediaType != telegram.Photo && mediaType != telegram.Video {
+			if err := r.SendMedia(ctx, file, caption); err != nil {
+				return err
+			}
+
+			caption = ""
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		if caption == "" || lastErr == nil {
+			// either something has been sent already or all items are duplicates
+			return nil
+		}
+
+		return r.Chat.SendMedia(ctx, receiver.MediaError{E: lastErr}, caption)
+	}
+
+	for len(files) > 0 {
+		size := MaxMediaGroupSize
+		if len(files) < size {
+			size = len(files)
+		}
+
+		if err := r.sendGroupChunk(ctx, files[:size], caption); err != nil {
+			return err
+		}
+
+		files = files[size:]
+		caption = ""
+	}
+
+	return nil
+}
+
+func (r *fileReceiver) sendGroupChunk(ctx context.Context, files []*fileRef, caption string) error {
+	if len(files) == 1 {
+		return r.SendMedia(ctx, files[0], caption)
+	}
+
+	var (
+		payload = make([]telegram.Media, len(files))
+		raw     bool
+	)
+
+	for i, file := range files {
+		media, _ := file.Get(ctx)
+		payload[i] = telegram.Media{
+			Type:  getMediaType(media.MIMEType),
+			Input: media.Input,
+		}
+
+		raw = raw || file.spoiler || file.videoMeta(payload[i].Type) != nil
+	}
+
+	payload[0].Caption = caption
+	payload[0].ParseMode = r.ParseMode
+	options := &telegram.SendOptions{DisableNotification: r.Silent}
+
+	var (
+		messages []telegram.Message
+		err      error
+	)
+
+	if raw && r.raw != nil {
+		messages, err = r.raw.sendGroup(ctx, r.ID, payload, files, options)
+	} else {
+		messages, err = r.groups.SendMediaGroup(ctx, r.ID, payload, options)
+	}
+
+	logf.Get(r).Resultf(ctx, logf.Debug, logf.Warn, "send media group of %d: %v", len(payload), err)
+	if isBadRequest(err) {
+		// some of the cached file IDs may have been rejected, so media are sent one by one
+		// in order to invalidate them
+		for i, file := range files {
+			if i > 0 {
+				caption = ""
+			}
+
+			if err := r.SendMedia(ctx, file, caption); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for i, file := range files {
+		if err == nil && i < len(messages) {
+			file.save(ctx, &mediaMessage{Message: messages[i]})
+		}
+
+		file.done()
+	}
+
+	return err
+}
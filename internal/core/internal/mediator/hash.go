@@ -1,27 +1,49 @@
 package mediator
 
 import (
+	"context"
 	"crypto/md5"
 	"fmt"
 	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
 	"github.com/corona10/goimagehash"
 	"github.com/jfk9w-go/flu"
 	"github.com/pkg/errors"
 	"golang.org/x/image/bmp"
+	"golang.org/x/image/webp"
 )
 
+// PerceptualHashTypes lists hash types which support similarity search.
+var PerceptualHashTypes = []string{"dhash"}
+
+const md5HashType = "md5"
+
 type readImageFunc func(io.Reader) (image.Image, error)
 
 var imageTypes = map[string]readImageFunc{
 	"image/jpeg": jpeg.Decode,
 	"image/png":  png.Decode,
 	"image/bmp":  bmp.Decode,
+	"image/gif":  gif.Decode,
+	"image/webp": webp.Decode,
+}
+
+// isStillGIF checks if the media is a GIF image with a single frame.
+// Such images skip hashers and are hashed as regular images, so that they match the same image in other formats.
+func isStillGIF(ctx context.Context, ref media.Ref, mimeType string) bool {
+	if mimeType != "image/gif" {
+		return false
+	}
+
+	animated, err := sniff(ctx, ref, isAnimatedGIF)
+	return err == nil && !animated
 }
 
 func hashImage(blob flu.Input, hash *feed.MediaHash, readImage readImageFunc) error {
@@ -41,8 +63,7 @@ func hashImage(blob flu.Input, hash *feed.MediaHash, readImage readImageFunc) er
 		return errors.Wrap(err, "get diff hash")
 	}
 
-	hash.Type = "dhash"
-	hash.Value = fmt.Sprintf("%x", dhash.GetHash())
+	hash.SetPerceptual("dhash", dhash.GetHash())
 	return nil
 }
 
@@ -52,7 +73,23 @@ func hashAny(blob flu.Input, hash *feed.MediaHash) error {
 		return errors.Wrap(err, "get md5 hash")
 	}
 
-	hash.Type = "md5"
+	hash.Type = md5HashType
 	hash.Value = fmt.Sprintf("%x", md5.Sum(nil))
 	return nil
 }
+
+// restoreHash restores a hash from its string representation.
+func restoreHash(hash *feed.MediaHash, hashType, value string) error {
+	if hashType == md5HashType {
+		hash.Type, hash.Value = hashType, value
+		return nil
+	}
+
+	perceptual, err := feed.ParsePerceptual(value)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", value)
+	}
+
+	hash.SetPerceptual(hashType, perceptual...)
+	return nil
+}
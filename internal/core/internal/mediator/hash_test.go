@@ -0,0 +1,84 @@
+package mediator
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+)
+
+func newTestGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	var img gif.GIF
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+		for x := 0; x < 16; x++ {
+			frame.SetColorIndex(x, x, uint8(i%2))
+		}
+
+		img.Image = append(img.Image, frame)
+		img.Delay = append(img.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &img); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestIsStillGIF(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mimeType string
+		data     []byte
+		still    bool
+	}{
+		{name: "still gif", mimeType: "image/gif", data: newTestGIF(t, 1), still: true},
+		{name: "animated gif", mimeType: "image/gif", data: newTestGIF(t, 2)},
+		{name: "broken gif", mimeType: "image/gif", data: []byte("GIF89a")},
+		{name: "not a gif", mimeType: "image/png", data: newTestGIF(t, 1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := media.LocalRef{Input: flu.Bytes(tc.data)}
+			if still := isStillGIF(context.Background(), ref, tc.mimeType); still != tc.still {
+				t.Errorf("expected %v, got %v", tc.still, still)
+			}
+		})
+	}
+}
+
+func TestRestoreHash(t *testing.T) {
+	for _, tc := range []struct {
+		name, hashType, value string
+		perceptual            bool
+	}{
+		{name: "md5", hashType: md5HashType, value: "d41d8cd98f00b204e9800998ecf8427e"},
+		{name: "dhash", hashType: "dhash", value: "abc", perceptual: true},
+		{name: "multi-frame hash", hashType: "vdhash64", value: "0000000000000abcffffffffffffffff", perceptual: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hash feed.MediaHash
+			if err := restoreHash(&hash, tc.hashType, tc.value); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if hash.Type != tc.hashType || hash.Value != tc.value {
+				t.Errorf("expected %s+%s, got %s+%s", tc.hashType, tc.value, hash.Type, hash.Value)
+			}
+
+			if _, ok := hash.Perceptual(); ok != tc.perceptual {
+				t.Errorf("expected perceptual = %v", tc.perceptual)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ package mediator
 import (
 	"context"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core/internal/blobs"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
@@ -18,24 +21,35 @@ import (
 	"github.com/pkg/errors"
 )
 
-var convertibleTypes = map[string]string{
-	"video/webm": "video/mp4",
-}
-
 type Impl struct {
-	Clock   syncf.Clock
-	Storage feed.MediaHashStorage
-	Blobs   feed.Blobs
-	Locker  syncf.Locker
-	Metrics me3x.Registry
-	Timeout time.Duration
-
-	resolvers  []media.Resolver
-	converters []media.Converter
-	ctx        context.Context
-	cancel     func()
-	work       syncf.WaitGroup
-	once       sync.Once
+	Clock        syncf.Clock
+	Storage      feed.MediaHashStorage
+	Files        feed.MediaFileStorage
+	Blobs        feed.Blobs
+	Locker       syncf.Locker
+	Metrics      me3x.Registry
+	Timeout      time.Duration
+	HashDistance int
+	Resolvers    map[string]ResolverConfig
+	HTTPFallback bool
+	Conversions  map[string]string
+	Hosts        map[string]HostLimitConfig
+	DedupScopes  map[string][]feed.ID
+	DefaultLimit HostLimitConfig
+
+	resolvers    []*resolverEntry
+	converters   map[media.Conversion][]media.Converter
+	compressors  []media.Compressor
+	hashers      []media.Hasher
+	audioProbers []media.AudioProber
+	videoProbers []media.VideoProber
+	listeners    []feed.DuplicateListener
+	limits       map[string]*hostLimit
+	limitsMu     sync.Mutex
+	ctx          context.Context
+	cancel       func()
+	work         syncf.WaitGroup
+	once         sync.Once
 }
 
 func (m *Impl) String() string {
@@ -46,65 +60,218 @@ func (m *Impl) init() {
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 }
 
-func (m *Impl) RegisterMediaResolver(resolver media.Resolver) {
-	m.resolvers = append(m.resolvers, resolver)
+func (m *Impl) RegisterMediaCompressor(compressor media.Compressor) {
+	m.compressors = append(m.compressors, compressor)
+}
+
+func (m *Impl) RegisterMediaHasher(hasher media.Hasher) {
+	m.hashers = append(m.hashers, hasher)
+}
+
+func (m *Impl) RegisterAudioProber(prober media.AudioProber) {
+	m.audioProbers = append(m.audioProbers, prober)
 }
 
-func (m *Impl) RegisterMediaConverter(converter media.Converter) {
-	m.converters = append(m.converters, converter)
+func (m *Impl) RegisterVideoProber(prober media.VideoProber) {
+	m.videoProbers = append(m.videoProbers, prober)
 }
 
-func (m *Impl) Mediate(ctx context.Context, source string, dedupKey *feed.ID) receiver.MediaRef {
+func (m *Impl) RegisterDuplicateListener(listener feed.DuplicateListener) {
+	m.listeners = append(m.listeners, listener)
+}
+
+func (m *Impl) Mediate(ctx context.Context, source string, options feed.MediaOptions) receiver.MediaRef {
 	url, err := url.Parse(source)
 	if err != nil {
 		return receiver.MediaError{E: err}
 	}
 
 	m.once.Do(m.init)
-	return syncf.AsyncWith[*receiver.Media](m.ctx, m.work.Spawn, func(ctx context.Context) (*receiver.Media, error) {
-		var dedup *dedupOpts
-		if dedupKey != nil {
-			dedup = &dedupOpts{
-				key:    *dedupKey,
-				source: url,
-			}
+	return m.spawn(url, nil, options)
+}
+
+// spawn starts mediation in background.
+// If resolved is not nil, it is used instead of resolving the source URL.
+func (m *Impl) spawn(source *url.URL, resolved media.MetaRef, options feed.MediaOptions) *fileRef {
+	file := &fileRef{
+		m:        m,
+		source:   source,
+		resolved: resolved,
+		spoiler:  options.Spoiler,
+		document: options.Document,
+		video:    options.Video,
+	}
+
+	if options.DedupKey != nil {
+		file.dedup = &dedupOpts{
+			key:      *options.DedupKey,
+			source:   source,
+			scope:    m.getDedupScope(*options.DedupKey, options.DedupScope),
+			spoiler:  options.Spoiler,
+			document: options.Document,
 		}
+	}
+
+	file.MediaRef = syncf.AsyncWith[*receiver.Media](m.ctx, m.work.Spawn, func(ctx context.Context) (*receiver.Media, error) {
+		return m.run(ctx, file)
+	})
 
-		ctx, cancel := context.WithTimeout(ctx, m.Timeout)
-		defer cancel()
+	return file
+}
+
+func (m *Impl) run(ctx context.Context, file *fileRef) (*receiver.Media, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// blobs stored during mediation are kept until the media is sent
+	ctx, hold := blobs.Hold(ctx)
+	logf.Get(m).Tracef(ctx, "mediating [%s]", file.source)
+	startTime := m.Clock.Now()
+	media, err := m.mediate(ctx, file)
+	if media == nil {
+		hold()
+	}
 
-		logf.Get(m).Tracef(ctx, "mediating [%s]", source)
-		startTime := m.Clock.Now()
-		media, err := m.mediate(ctx, url, dedup)
-		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn,
-			"mediated [%s] in %s: %v", source, m.Clock.Now().Sub(startTime), err)
+	logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn,
+		"mediated [%s] in %s: %v", file.source, m.Clock.Now().Sub(startTime), err)
 
-		if errors.Is(err, errDuplicate) {
-			return nil, nil
+	if errors.Is(err, errDuplicate) {
+		if file.hash != nil {
+			m.afterDuplicate(ctx, file.hash)
 		}
 
+		return nil, nil
+	}
+
+	if media != nil {
+		if file.animation && getMediaType(media.MIMEType) == telegram.Video {
+			// videos converted from animated images are sent as animations which loop and autoplay without sound
+			media.MIMEType += animatedVariant
+			file.filename = animationFilename
+		}
+
+		file.mimeType = media.MIMEType
+		release := m.Blobs.Acquire(ctx, media.Input)
+		file.release = func() {
+			release()
+			hold()
+		}
+
+		switch {
+		case file.cached != nil:
+		case getMediaType(media.MIMEType) == telegram.Audio:
+			m.probeAudio(ctx, file, media)
+		case getMediaType(media.MIMEType) == telegram.Video:
+			m.probeVideo(ctx, file, media)
+		}
+	}
+
+	return media, err
+}
+
+func (m *Impl) mediate(ctx context.Context, file *fileRef) (*receiver.Media, error) {
+	if media, err := m.getMediaFile(ctx, file); media != nil || err != nil {
 		return media, err
-	})
+	}
+
+	host := file.source.Hostname()
+	config := m.getHostLimitConfig(host)
+	backoff := config.Backoff.Value
+	for attempt := 0; ; attempt++ {
+		media, err := m.download(ctx, file)
+		if err == nil || attempt >= config.Retries || !isTemporary(err) {
+			return media, err
+		}
+
+		labels := make(me3x.Labels, 0, 1).
+			Add("origin", host)
+		m.Metrics.Counter("retried", labels).Inc()
+		logf.Get(m).Warnf(ctx, "retry [%s] in %s after attempt %d: %v", file.source, backoff, attempt+1, err)
+		if err := flu.Sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+
+		backoff *= 2
+	}
 }
 
-func (m *Impl) mediate(ctx context.Context, source *url.URL, dedup *dedupOpts) (*receiver.Media, error) {
-	metaRef, err := m.resolve(ctx, source)
-	if err != nil {
+// download performs a single mediation attempt.
+// Resolution and download are limited by source and download hosts respectively,
+// while processing the downloaded media is not limited.
+func (m *Impl) download(ctx context.Context, file *fileRef) (*receiver.Media, error) {
+	var (
+		metaRef media.MetaRef
+		meta    *media.Meta
+	)
+
+	if err := m.limited(ctx, file.source.Hostname(), func() (err error) {
+		metaRef, meta, err = m.resolveFile(ctx, file)
+		return
+	}); err != nil {
 		return nil, err
 	}
 
-	meta, ref, err := m.convert(ctx, metaRef, dedup)
-	if err != nil {
+	var ref media.Ref
+	if file.dedup != nil {
+		ref = m.Blobs.Buffer(meta.MIMEType, metaRef)
+	} else {
+		ref = m.bufferLeaveURL(meta.MIMEType, metaRef)
+	}
+
+	if err := m.limited(ctx, downloadHost(file.source, metaRef), func() error {
+		_, err := ref.Get(ctx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if meta, err = m.reconcileMIMEType(ctx, meta, ref); err != nil {
 		return nil, err
 	}
 
-	m.incrementCounter(source, dedup, meta, err)
-	mediaType := telegram.MediaTypeByMIMEType(meta.MIMEType)
-	if mediaType == telegram.DefaultMediaType {
+	file.filename = getFilename(file.source, meta)
+
+	// the hash is saved to storage once it is checked, so it is not checked again on retry
+	// since it would match itself otherwise
+	if file.dedup != nil && file.hash == nil {
+		if file.hash, err = m.dedup(ctx, meta.MIMEType, ref, file.dedup); err != nil {
+			return nil, err
+		}
+
+		if media, err := m.getMediaFile(ctx, file); media != nil || err != nil {
+			return media, err
+		}
+	}
+
+	if file.document && strings.HasPrefix(meta.MIMEType, "image/") {
+		// images are sent as is in order to preserve their quality
+		meta = &media.Meta{MIMEType: documentMIMEType, Size: meta.Size}
+	} else {
+		mimeType := meta.MIMEType
+		meta, ref, file.animation, err = m.convert(ctx, meta, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if meta.MIMEType != mimeType {
+			// the original file extension does not match anymore
+			file.filename = ""
+		}
+
+		meta, ref, err = m.fitPhoto(ctx, meta, ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "fit photo")
+		}
+	}
+
+	m.incrementCounter(file.source, file.dedup, meta, err)
+	mediaType := getMediaType(meta.MIMEType)
+	if mediaType == telegram.Document && !isDocumentType(meta.MIMEType) {
 		return nil, errors.Errorf("mime type %s is not supported", meta.MIMEType)
 	}
 
-	if meta.Size > 0 && int64(meta.Size) <= mediaType.RemoteMaxSize() {
+	if meta.Size > 0 && int64(meta.Size) <= mediaType.RemoteMaxSize() && canSendURL(meta.MIMEType, mediaType) {
 		input, err := ref.Get(ctx)
 		if err != nil {
 			return nil, err
@@ -117,23 +284,62 @@ func (m *Impl) mediate(ctx context.Context, source *url.URL, dedup *dedupOpts) (
 	}
 
 	if int64(meta.Size) <= mediaType.AttachMaxSize() {
-		ref := m.Blobs.Buffer(meta.MIMEType, ref)
-		meta, err := ref.GetMeta(ctx)
-		if err != nil {
+		buffered := m.Blobs.Buffer(meta.MIMEType, ref)
+		bufferedMeta, err := buffered.GetMeta(ctx)
+		switch {
+		case errors.Is(err, blobs.ErrTooLarge):
+			// try to compress the original media
+		case err != nil:
 			return nil, err
-		}
-
-		if int64(meta.Size) <= mediaType.AttachMaxSize() {
-			input, err := ref.Get(ctx)
+		case int64(bufferedMeta.Size) <= mediaType.AttachMaxSize():
+			input, err := buffered.Get(ctx)
 			if err != nil {
 				return nil, err
 			}
 
 			return &receiver.Media{
 				Input:    input,
-				MIMEType: meta.MIMEType,
+				MIMEType: bufferedMeta.MIMEType,
 			}, nil
+		default:
+			meta, ref = bufferedMeta, buffered
+		}
+	}
+
+	return m.compress(ctx, meta, ref, mediaType.AttachMaxSize())
+}
+
+func (m *Impl) compress(ctx context.Context, meta *media.Meta, ref media.Ref, maxSize int64) (*receiver.Media, error) {
+	for _, compressor := range m.compressors {
+		metaRef, err := compressor.Compress(ctx, ref, meta.MIMEType, media.Size(maxSize))
+		if metaRef == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "compress [%s] with [%s]: %v", meta.Size, compressor, err)
+		if err != nil {
+			continue
+		}
+
+		meta, err := metaRef.GetMeta(ctx)
+		if err != nil {
+			return nil, err
 		}
+
+		if int64(meta.Size) > maxSize {
+			logf.Get(m).Warnf(ctx, "compressed with [%s] to %s, which is still too large", compressor, meta.Size)
+			continue
+		}
+
+		input, err := metaRef.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &receiver.Media{
+			Input:    input,
+			MIMEType: meta.MIMEType,
+		}, nil
 	}
 
 	return nil, errors.Errorf("size %s too large", meta.Size)
@@ -159,78 +365,100 @@ func (m *Impl) incrementCounter(source *url.URL, dedup *dedupOpts, meta *media.M
 
 }
 
-func (m *Impl) convert(ctx context.Context, metaRef media.MetaRef, dedup *dedupOpts) (*media.Meta, media.Ref, error) {
-	meta, err := metaRef.GetMeta(ctx)
+func (m *Impl) dedup(ctx context.Context, mimeType string, ref media.Ref, dedup *dedupOpts) (*feed.MediaHash, error) {
+	input, err := ref.Get(ctx)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "get meta")
-	}
-
-	var ref media.Ref
-	if dedup != nil {
-		ref = m.Blobs.Buffer(meta.MIMEType, metaRef)
-		if err := m.dedup(ctx, meta.MIMEType, ref, dedup); err != nil {
-			return nil, nil, err
-		}
-	} else {
-		ref = m.bufferLeaveURL(meta.MIMEType, metaRef)
+		return nil, err
 	}
 
-	if mimeType, ok := convertibleTypes[meta.MIMEType]; ok {
-		for _, converter := range m.converters {
-			metaRef, err := converter.Convert(ctx, ref, mimeType)
-			if metaRef == nil && err == nil {
-				continue
-			}
+	hash := m.newMediaHash(dedup)
 
-			logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "convert with [%s]: %v", converter, err)
-			if metaRef != nil {
-				return m.convert(ctx, metaRef, nil)
+	// hashers go first so that animated images are hashed as videos when possible
+	if isStillGIF(ctx, ref, mimeType) || !m.hashPerceptual(ctx, ref, mimeType, hash) {
+		if readImage, ok := imageTypes[mimeType]; ok {
+			err = hashImage(input, hash, readImage)
+			if err != nil && mimeType == "image/webp" {
+				// animated WebP images are not supported by the decoder
+				logf.Get(m).Debugf(ctx, "fallback to md5 for [%s]: %v", hash.URL, err)
+				err = hashAny(input, hash)
 			}
+		} else {
+			err = hashAny(input, hash)
 		}
 	}
 
-	return meta, ref, nil
-}
-
-func (m *Impl) dedup(ctx context.Context, mimeType string, ref media.Ref, dedup *dedupOpts) error {
-	input, err := ref.Get(ctx)
+	logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "hash media [%s => %s]: %v", hash.URL, hash.Value, err)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	switch err := m.checkUnique(ctx, hash); {
+	case errors.Is(err, errDuplicate):
+		return hash, err
+	case err != nil:
+		// the hash may have not been saved
+		return nil, err
+	default:
+		return hash, nil
 	}
+}
 
+func (m *Impl) newMediaHash(dedup *dedupOpts) *feed.MediaHash {
 	now := m.Clock.Now()
-	hash := &feed.MediaHash{
+	return &feed.MediaHash{
 		FeedID:    dedup.key,
 		URL:       dedup.source.String(),
 		FirstSeen: now,
 		LastSeen:  now,
+		Scope:     dedup.scope,
+		Spoiler:   dedup.spoiler,
+		Document:  dedup.document,
 	}
+}
 
-	if readImage, ok := imageTypes[mimeType]; ok {
-		err = hashImage(input, hash, readImage)
-	} else {
-		err = hashAny(input, hash)
-	}
-
-	logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "hash media [%s => %s]: %v", hash.URL, hash.Value, err)
-	if err != nil {
-		return err
-	}
-
-	ok, err := m.Storage.IsMediaUnique(ctx, hash)
+func (m *Impl) checkUnique(ctx context.Context, hash *feed.MediaHash) error {
+	ok, err := m.Storage.IsMediaUnique(ctx, hash, m.HashDistance)
 	if err != nil {
 		return err
 	}
 
 	if !ok {
+		if hash.MatchedURL.Valid {
+			logf.Get(m).Debugf(ctx, "media [%s] matches [%s]", hash.URL, hash.MatchedURL.String)
+		}
+
 		return errDuplicate
 	}
 
 	return nil
 }
 
+func (m *Impl) afterDuplicate(ctx context.Context, hash *feed.MediaHash) {
+	for _, listener := range m.listeners {
+		err := listener.AfterDuplicate(ctx, hash)
+		logf.Get(m).Resultf(ctx, logf.Trace, logf.Warn, "after duplicate [%s] for [%s]: %v", hash.URL, listener, err)
+	}
+}
+
+func (m *Impl) hashPerceptual(ctx context.Context, ref media.Ref, mimeType string, hash *feed.MediaHash) bool {
+	for _, hasher := range m.hashers {
+		value, err := hasher.Hash(ctx, ref, mimeType)
+		if value == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "hash [%s] with [%s]: %v", hash.URL, hasher, err)
+		if value != nil {
+			hash.SetPerceptual(value.Type, value.Values...)
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m *Impl) bufferLeaveURL(mimeType string, ref media.Ref) media.Ref {
-	return syncf.Resolve[flu.Input](func(ctx context.Context) (flu.Input, error) {
+	leaveURL := syncf.Lazy[flu.Input](func(ctx context.Context) (flu.Input, error) {
 		input, err := ref.Get(ctx)
 		if err != nil {
 			return nil, err
@@ -242,24 +470,22 @@ func (m *Impl) bufferLeaveURL(mimeType string, ref media.Ref) media.Ref {
 
 		return m.Blobs.Buffer(mimeType, ref).Get(ctx)
 	})
-}
-
-func (m *Impl) resolve(ctx context.Context, source *url.URL) (metaRef media.MetaRef, err error) {
-	for _, resolver := range m.resolvers {
-		metaRef, err = resolver.Resolve(ctx, source)
-		if metaRef == nil && err == nil {
-			continue
-		}
 
-		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "resolve [%s] with [%s]: %v", source, resolver, err)
-		if metaRef != nil {
-			logf.Get(m).Debugf(ctx, "resolve [%s] with [%s]: ok", source, resolver)
-			return
-		}
+	if downloader, ok := ref.(media.Downloader); ok {
+		// keep efficient downloads for buffering the media later
+		return downloaderRef{Downloader: downloader, ref: leaveURL}
 	}
 
-	logf.Get(m).Debugf(ctx, "resolve [%s] as http ref", source)
-	return &media.HTTPRef{URL: source.String()}, nil
+	return leaveURL
+}
+
+type downloaderRef struct {
+	media.Downloader
+	ref media.Ref
+}
+
+func (r downloaderRef) Get(ctx context.Context) (flu.Input, error) {
+	return r.ref.Get(ctx)
 }
 
 func (m *Impl) Close() error {
@@ -272,6 +498,34 @@ func (m *Impl) Close() error {
 }
 
 type dedupOpts struct {
-	key    feed.ID
-	source *url.URL
+	key      feed.ID
+	source   *url.URL
+	scope    []feed.ID
+	spoiler  bool
+	document bool
+}
+
+// getDedupScope returns IDs of feeds sharing media deduplication with the feed.
+// If no scope name is provided, all configured scopes containing the feed are used.
+func (m *Impl) getDedupScope(feedID feed.ID, name string) []feed.ID {
+	switch name {
+	case feed.LocalDedupScope:
+		return nil
+	case "":
+		var scope []feed.ID
+		for _, feedIDs := range m.DedupScopes {
+			if slices.Contains(feedIDs, feedID) {
+				scope = append(scope, feedIDs...)
+			}
+		}
+
+		return scope
+	default:
+		scope, ok := m.DedupScopes[name]
+		if !ok {
+			logf.Get(m).Warnf(m.ctx, "dedup scope [%s] is not configured, using [%d] only", name, feedID)
+		}
+
+		return scope
+	}
 }
@@ -0,0 +1,190 @@
+package mediator
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/core/internal/blobs"
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+// testHashStorage reports hashes as duplicate once they have been saved, like the real storage does.
+type testHashStorage struct {
+	feed.MediaHashStorage
+	saved map[string]bool
+}
+
+func (s *testHashStorage) IsMediaUnique(ctx context.Context, hash *feed.MediaHash, maxDistance int) (bool, error) {
+	if s.saved[hash.Value] {
+		return false, nil
+	}
+
+	s.saved[hash.Value] = true
+	return true, nil
+}
+
+type testFileStorage struct {
+	feed.MediaFileStorage
+}
+
+func (testFileStorage) GetMediaFile(ctx context.Context, url string, hash *feed.MediaHash) (*feed.MediaFile, error) {
+	return nil, nil
+}
+
+// testConverter produces media which fail to be read with the passed errors first.
+type testConverter struct {
+	conversion media.Conversion
+	errs       []error
+}
+
+func (c *testConverter) String() string {
+	return "test"
+}
+
+func (c *testConverter) Conversions() []media.Conversion {
+	return []media.Conversion{c.conversion}
+}
+
+func (c *testConverter) Convert(ctx context.Context, ref media.Ref, conversion media.Conversion) (media.MetaRef, error) {
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return errorRef{err: err}, nil
+	}
+
+	return &media.LocalRef{
+		Input: flu.Bytes{1, 2, 3},
+		Meta:  &media.Meta{MIMEType: conversion.To, Size: 3},
+	}, nil
+}
+
+// errorRef is a media.MetaRef which fails to be read, like a blob failed to be uploaded.
+type errorRef struct {
+	err error
+}
+
+func (r errorRef) GetMeta(ctx context.Context) (*media.Meta, error) {
+	return nil, r.err
+}
+
+func (r errorRef) Get(ctx context.Context) (flu.Input, error) {
+	return nil, r.err
+}
+
+// TestMediateRetryAfterDedup checks that the hash saved during a failed attempt does not turn
+// the media into a duplicate of itself on retry.
+func TestMediateRetryAfterDedup(t *testing.T) {
+	storage := &testHashStorage{saved: make(map[string]bool)}
+	m := &Impl{
+		Clock:        syncf.DefaultClock,
+		Storage:      storage,
+		Files:        testFileStorage{},
+		Blobs:        &blobs.Memory{SizeBounds: [2]media.Size{1, 1 << 20}},
+		Metrics:      me3x.DummyRegistry{},
+		Conversions:  map[string]string{"application/x-test": "application/x-converted"},
+		DefaultLimit: HostLimitConfig{Concurrency: 1, Retries: 1},
+	}
+
+	m.RegisterMediaConverter(&testConverter{
+		conversion: media.Conversion{From: "application/x-test", To: "application/x-converted"},
+		errs: []error{
+			httpf.StatusCodeError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"},
+		},
+	})
+
+	source, _ := url.Parse("https://example.com/media")
+	data := flu.Bytes{0, 1, 2, 3, 0xff}
+	file := &fileRef{
+		m:      m,
+		source: source,
+		resolved: &media.LocalRef{
+			Input: data,
+			Meta:  &media.Meta{MIMEType: "application/x-test", Size: media.Size(len(data))},
+		},
+		dedup: &dedupOpts{key: feed.ID(1), source: source},
+	}
+
+	result, err := m.mediate(context.Background(), file)
+	switch {
+	case err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case result == nil:
+		t.Fatal("expected media, got nil")
+	case result.MIMEType != "application/x-converted":
+		t.Errorf("expected converted media, got %s", result.MIMEType)
+	case len(storage.saved) != 1:
+		t.Errorf("expected a single saved hash, got %d", len(storage.saved))
+	}
+}
+
+func TestMediateAnimation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		mediaType telegram.MediaType
+		filename  string
+	}{
+		{
+			name:      "animated",
+			data:      newTestGIF(t, 2),
+			mediaType: telegram.Animation,
+			filename:  animationFilename,
+		},
+		{
+			name:      "still",
+			data:      newTestGIF(t, 1),
+			mediaType: telegram.Video,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Impl{
+				Clock:   syncf.DefaultClock,
+				Files:   testFileStorage{},
+				Blobs:   &blobs.Memory{SizeBounds: [2]media.Size{1, 1 << 20}},
+				Metrics: me3x.DummyRegistry{},
+				Timeout: time.Minute,
+				Conversions: map[string]string{
+					"image/gif+animated": "video/mp4",
+					// still images are converted to videos as well in order to check that they are not sent as animations
+					"image/gif": "video/mp4",
+				},
+				DefaultLimit: HostLimitConfig{Concurrency: 1},
+			}
+
+			m.RegisterMediaConverter(&testConverter{conversion: media.Conversion{From: "image/gif", To: "video/mp4"}})
+			source, _ := url.Parse("https://example.com/media.gif")
+			file := &fileRef{
+				m:      m,
+				source: source,
+				resolved: &media.LocalRef{
+					Input: flu.Bytes(tc.data),
+					Meta:  &media.Meta{MIMEType: "image/gif", Size: media.Size(len(tc.data))},
+				},
+			}
+
+			result, err := m.run(context.Background(), file)
+			switch {
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case result == nil:
+				t.Fatal("expected media, got nil")
+			case getMediaType(result.MIMEType) != tc.mediaType:
+				t.Errorf("expected %s, got %s", tc.mediaType, getMediaType(result.MIMEType))
+			case getMediaType(file.mimeType) != tc.mediaType:
+				t.Errorf("expected %s for sending, got %s", tc.mediaType, getMediaType(file.mimeType))
+			case file.filename != tc.filename:
+				t.Errorf("expected filename %q, got %q", tc.filename, file.filename)
+			}
+		})
+	}
+}
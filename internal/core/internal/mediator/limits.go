@@ -0,0 +1,247 @@
+package mediator
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/pkg/errors"
+)
+
+// queueWaitBuckets are histogram buckets for download queue wait time in seconds.
+var queueWaitBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 300}
+
+type HostLimitConfig struct {
+	Concurrency int          `yaml:"concurrency,omitempty" doc:"Maximum concurrent downloads from the host. Inherited from media settings if zero."`
+	Rate        float64      `yaml:"rate,omitempty" doc:"Maximum downloads started per second (token bucket refill rate). Zero means no limit."`
+	Burst       int          `yaml:"burst,omitempty" doc:"Maximum downloads started at once after a pause (token bucket size)."`
+	Retries     int          `yaml:"retries,omitempty" doc:"How many times to retry downloads failed with temporary errors. Inherited from media settings if zero."`
+	Backoff     flu.Duration `yaml:"backoff,omitempty" doc:"Delay before the first retry. It is doubled after each attempt. Inherited from media settings if zero."`
+}
+
+// hostLimitTTL is how long limits state of a host is kept after the last download.
+// State is also kept until the token bucket is full, so that eviction does not reset rate limits.
+const hostLimitTTL = 10 * time.Minute
+
+// hostLimit keeps download limits state for a single host.
+type hostLimit struct {
+	config HostLimitConfig
+	slots  chan struct{}
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+
+	// users and used are guarded by Impl.limitsMu.
+	users int
+	used  time.Time
+}
+
+// reserve takes a token from the bucket and returns how long to wait until it is available.
+func (l *hostLimit) reserve(now time.Time) time.Duration {
+	if l.config.Rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.config.Burst)
+	l.tokens += now.Sub(l.last).Seconds() * l.config.Rate
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.config.Rate * float64(time.Second))
+}
+
+// idle checks if the limits state is not used and may be evicted.
+func (l *hostLimit) idle(now time.Time) bool {
+	if l.users > 0 {
+		return false
+	}
+
+	ttl := hostLimitTTL
+	if l.config.Rate > 0 {
+		if refill := time.Duration(float64(l.config.Burst) / l.config.Rate * float64(time.Second)); refill > ttl {
+			ttl = refill
+		}
+	}
+
+	return now.Sub(l.used) >= ttl
+}
+
+// getHostLimitConfig returns download limits for the host.
+// Limits are configured by the most specific matching host pattern,
+// where a pattern matches the host itself and its subdomains.
+func (m *Impl) getHostLimitConfig(host string) HostLimitConfig {
+	config, pattern := m.DefaultLimit, ""
+	for domain, domainConfig := range m.Hosts {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(pattern) {
+			config, pattern = domainConfig, domain
+		}
+	}
+
+	if config.Concurrency <= 0 {
+		config.Concurrency = m.DefaultLimit.Concurrency
+	}
+
+	if config.Retries <= 0 {
+		config.Retries = m.DefaultLimit.Retries
+	}
+
+	if config.Backoff.Value <= 0 {
+		config.Backoff = m.DefaultLimit.Backoff
+	}
+
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+
+	return config
+}
+
+// getHostLimit returns limits state for the host and marks it as used until putHostLimit is called.
+// Idle limits of other hosts are evicted when a new host is seen.
+func (m *Impl) getHostLimit(host string) *hostLimit {
+	m.limitsMu.Lock()
+	defer m.limitsMu.Unlock()
+
+	limit, ok := m.limits[host]
+	if !ok {
+		now := m.Clock.Now()
+		for other, otherLimit := range m.limits {
+			if otherLimit.idle(now) {
+				delete(m.limits, other)
+			}
+		}
+
+		config := m.getHostLimitConfig(host)
+		limit = &hostLimit{
+			config: config,
+			tokens: float64(config.Burst),
+			last:   now,
+		}
+
+		if config.Concurrency > 0 {
+			limit.slots = make(chan struct{}, config.Concurrency)
+		}
+
+		if m.limits == nil {
+			m.limits = make(map[string]*hostLimit)
+		}
+
+		m.limits[host] = limit
+	}
+
+	limit.users++
+	return limit
+}
+
+// putHostLimit marks limits state as not used by the caller anymore.
+func (m *Impl) putHostLimit(limit *hostLimit) {
+	m.limitsMu.Lock()
+	defer m.limitsMu.Unlock()
+	limit.users--
+	limit.used = m.Clock.Now()
+}
+
+// acquire waits until a download from the host is allowed by its limits and the global concurrency limit.
+// The returned function releases the concurrency slots.
+func (m *Impl) acquire(ctx context.Context, host string) (release func(), err error) {
+	limit := m.getHostLimit(host)
+	defer func() {
+		if err != nil {
+			m.putHostLimit(limit)
+		}
+	}()
+
+	startTime := m.Clock.Now()
+	if err := flu.Sleep(ctx, limit.reserve(startTime)); err != nil {
+		return nil, err
+	}
+
+	releaseSlot := func() {}
+	if limit.slots != nil {
+		select {
+		case limit.slots <- struct{}{}:
+			releaseSlot = func() { <-limit.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	unlock := func() {}
+	if m.Locker != nil {
+		var lockCtx context.Context
+		lockCtx, unlock = m.Locker.Lock(ctx)
+		if err := lockCtx.Err(); err != nil {
+			unlock()
+			releaseSlot()
+			return nil, err
+		}
+	}
+
+	labels := make(me3x.Labels, 0, 1).
+		Add("origin", host)
+	m.Metrics.Histogram("queue_wait_seconds", labels, queueWaitBuckets).
+		Observe(m.Clock.Now().Sub(startTime).Seconds())
+	return func() {
+		unlock()
+		releaseSlot()
+		m.putHostLimit(limit)
+	}, nil
+}
+
+// limited runs the body within download limits of the host.
+func (m *Impl) limited(ctx context.Context, host string, body func() error) error {
+	release, err := m.acquire(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	return body()
+}
+
+// downloadHost returns the host the media is downloaded from.
+// The source host is used if it is unknown.
+func downloadHost(source *url.URL, metaRef media.MetaRef) string {
+	if ref, ok := metaRef.(*media.HTTPRef); ok {
+		if url, err := url.Parse(ref.URL); err == nil && url.Hostname() != "" {
+			return url.Hostname()
+		}
+	}
+
+	return source.Hostname()
+}
+
+// isTemporary checks if the download error is worth retrying.
+func isTemporary(err error) bool {
+	var statusErr httpf.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
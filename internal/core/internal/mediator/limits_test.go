@@ -0,0 +1,146 @@
+package mediator
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/jfk9w-go/flu/syncf"
+)
+
+func TestHostLimitReserve(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	type step struct {
+		after time.Duration
+		wait  time.Duration
+	}
+
+	for _, tc := range []struct {
+		name   string
+		config HostLimitConfig
+		steps  []step
+	}{
+		{
+			name:   "no rate limit",
+			config: HostLimitConfig{Burst: 1},
+			steps:  []step{{}, {}, {}},
+		},
+		{
+			name:   "burst",
+			config: HostLimitConfig{Rate: 1, Burst: 2},
+			steps:  []step{{}, {}, {wait: time.Second}, {wait: 2 * time.Second}},
+		},
+		{
+			name:   "refill",
+			config: HostLimitConfig{Rate: 2, Burst: 1},
+			steps:  []step{{}, {after: 250 * time.Millisecond, wait: 250 * time.Millisecond}, {after: time.Second}},
+		},
+		{
+			name:   "refill is capped by burst",
+			config: HostLimitConfig{Rate: 1, Burst: 2},
+			steps:  []step{{}, {after: time.Hour}, {}, {wait: time.Second}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limit := &hostLimit{config: tc.config, tokens: float64(tc.config.Burst), last: start}
+			now := start
+			for i, step := range tc.steps {
+				now = now.Add(step.after)
+				if wait := limit.reserve(now); wait != step.wait {
+					t.Errorf("step %d: expected wait %s, got %s", i, step.wait, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestGetHostLimitConfig(t *testing.T) {
+	m := &Impl{
+		DefaultLimit: HostLimitConfig{Concurrency: 3, Retries: 2},
+		Hosts: map[string]HostLimitConfig{
+			"imgur.com":   {Concurrency: 2, Rate: 1, Burst: 5},
+			"i.imgur.com": {Rate: 2},
+		},
+	}
+
+	for _, tc := range []struct {
+		host   string
+		config HostLimitConfig
+	}{
+		{host: "example.com", config: HostLimitConfig{Concurrency: 3, Retries: 2, Burst: 1}},
+		{host: "imgur.com", config: HostLimitConfig{Concurrency: 2, Retries: 2, Rate: 1, Burst: 5}},
+		{host: "api.imgur.com", config: HostLimitConfig{Concurrency: 2, Retries: 2, Rate: 1, Burst: 5}},
+		{host: "i.imgur.com", config: HostLimitConfig{Concurrency: 3, Retries: 2, Rate: 2, Burst: 1}},
+		{host: "notimgur.com", config: HostLimitConfig{Concurrency: 3, Retries: 2, Burst: 1}},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			if config := m.getHostLimitConfig(tc.host); config != tc.config {
+				t.Errorf("expected %+v, got %+v", tc.config, config)
+			}
+		})
+	}
+}
+
+func TestHostLimitEviction(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := &Impl{
+		Clock:        syncf.ClockFunc(func() time.Time { return now }),
+		Metrics:      me3x.DummyRegistry{},
+		DefaultLimit: HostLimitConfig{Concurrency: 1},
+		Hosts: map[string]HostLimitConfig{
+			"slow.example.com": {Rate: 0.001, Burst: 1},
+		},
+	}
+
+	ctx := context.Background()
+	for _, host := range []string{"a.example.com", "b.example.com", "slow.example.com"} {
+		release, err := m.acquire(ctx, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if host != "b.example.com" {
+			release()
+		}
+	}
+
+	now = now.Add(hostLimitTTL)
+	m.getHostLimit("c.example.com")
+
+	for host, expected := range map[string]bool{
+		// idle for TTL
+		"a.example.com": false,
+		// download is in progress
+		"b.example.com": true,
+		// token bucket is not full yet
+		"slow.example.com": true,
+		"c.example.com":    true,
+	} {
+		if _, ok := m.limits[host]; ok != expected {
+			t.Errorf("expected %s to be kept: %t, got %t", host, expected, ok)
+		}
+	}
+}
+
+func TestDownloadHost(t *testing.T) {
+	source, _ := url.Parse("https://imgur.com/a/abc")
+	for _, tc := range []struct {
+		name    string
+		metaRef media.MetaRef
+		host    string
+	}{
+		{name: "http", metaRef: &media.HTTPRef{URL: "https://i.imgur.com/abc.jpg"}, host: "i.imgur.com"},
+		{name: "invalid url", metaRef: &media.HTTPRef{URL: "://"}, host: "imgur.com"},
+		{name: "other", metaRef: &media.LocalRef{}, host: "imgur.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if host := downloadHost(source, tc.metaRef); host != tc.host {
+				t.Errorf("expected %s, got %s", tc.host, host)
+			}
+		})
+	}
+}
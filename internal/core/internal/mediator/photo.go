@@ -0,0 +1,134 @@
+package mediator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+// Telegram photo constraints.
+const (
+	photoMaxDimensions  = 10000
+	photoMaxAspectRatio = 20
+)
+
+const (
+	// photoMaxPixels limits memory consumption when decoding photos.
+	photoMaxPixels       = 50_000_000
+	photoDownscaleFactor = 0.75
+	photoMaxDownscales   = 4
+	documentMIMEType     = "application/octet-stream"
+)
+
+var photoQualities = []int{90, 80, 70}
+
+// fitPhoto checks if the photo conforms to Telegram photo constraints.
+// Photos which are too large are resized and re-encoded as JPEG.
+// Photos with extreme aspect ratio (or which are too large to be processed) are sent as documents.
+func (m *Impl) fitPhoto(ctx context.Context, meta *media.Meta, ref media.Ref) (*media.Meta, media.Ref, error) {
+	if telegram.MediaTypeByMIMEType(meta.MIMEType) != telegram.Photo {
+		return meta, ref, nil
+	}
+
+	config, err := sniff(ctx, ref, func(reader *bufio.Reader) (image.Config, error) {
+		config, _, err := image.DecodeConfig(reader)
+		return config, err
+	})
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode image config")
+	}
+
+	width, height := config.Width, config.Height
+	if width <= 0 || height <= 0 {
+		return nil, nil, errors.Errorf("invalid image dimensions %dx%d", width, height)
+	}
+
+	if max(width, height) > photoMaxAspectRatio*min(width, height) || width*height > photoMaxPixels {
+		logf.Get(m).Debugf(ctx, "sending %dx%d image as document", width, height)
+		return &media.Meta{MIMEType: documentMIMEType, Size: meta.Size}, ref, nil
+	}
+
+	if meta.Size < 0 {
+		metaRef := m.Blobs.Buffer(meta.MIMEType, ref)
+		if meta, err = metaRef.GetMeta(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		ref = metaRef
+	}
+
+	if width+height <= photoMaxDimensions && int64(meta.Size) <= telegram.Photo.AttachMaxSize() {
+		return meta, ref, nil
+	}
+
+	startTime := m.Clock.Now()
+	data, err := m.downscalePhoto(ctx, ref, width, height)
+	logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn,
+		"downscale %dx%d image (%s) in %s: %v", width, height, meta.Size, m.Clock.Now().Sub(startTime), err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metaRef := m.Blobs.Buffer("image/jpeg", syncf.Val[flu.Input]{V: flu.Bytes(data)})
+	meta, err = metaRef.GetMeta(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return meta, metaRef, nil
+}
+
+func (m *Impl) downscalePhoto(ctx context.Context, ref media.Ref, width, height int) ([]byte, error) {
+	src, err := sniff(ctx, ref, func(reader *bufio.Reader) (image.Image, error) {
+		src, _, err := image.Decode(reader)
+		return src, err
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	scale := 1.
+	if width+height > photoMaxDimensions {
+		scale = float64(photoMaxDimensions) / float64(width+height)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i <= photoMaxDownscales; i++ {
+		bounds := image.Rect(0, 0, max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1))
+		dst := image.NewRGBA(bounds)
+		// JPEG does not support transparency
+		draw.Draw(dst, bounds, image.White, image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, bounds, src, src.Bounds(), draw.Over, nil)
+		for _, quality := range photoQualities {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			buf.Reset()
+			if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, errors.Wrap(err, "encode image")
+			}
+
+			if int64(buf.Len()) <= telegram.Photo.AttachMaxSize() {
+				return buf.Bytes(), nil
+			}
+		}
+
+		scale *= photoDownscaleFactor
+	}
+
+	return nil, errors.Errorf("unable to fit image into %s", media.Size(telegram.Photo.AttachMaxSize()))
+}
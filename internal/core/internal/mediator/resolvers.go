@@ -0,0 +1,234 @@
+package mediator
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/pkg/errors"
+)
+
+const resolverPrefix = "media-resolver."
+
+type ResolverConfig struct {
+	Disabled bool     `yaml:"disabled,omitempty" doc:"Whether the resolver should be disabled."`
+	Priority int      `yaml:"priority,omitempty" doc:"Resolvers with higher priority are tried first. Overrides built-in priority if set."`
+	Hosts    []string `yaml:"hosts,omitempty" doc:"Hosts the resolver is applicable to (subdomains are matched as well). Overrides built-in hosts if set."`
+	Patterns []string `yaml:"patterns,omitempty" doc:"Regular expressions matching URLs the resolver is applicable to. Overrides built-in patterns if set."`
+}
+
+// ResolverName returns resolver name used in configuration.
+func ResolverName(resolver media.Resolver) string {
+	return strings.TrimPrefix(resolver.String(), resolverPrefix)
+}
+
+type resolverEntry struct {
+	media.Resolver
+	name     string
+	hosts    []string
+	patterns []*regexp.Regexp
+	priority int
+}
+
+func newResolverEntry(resolver media.Resolver, config ResolverConfig) (*resolverEntry, error) {
+	var rule media.ResolverRule
+	if resolver, ok := resolver.(media.RuleResolver); ok {
+		rule = resolver.ResolverRule()
+	}
+
+	if config.Priority != 0 {
+		rule.Priority = config.Priority
+	}
+
+	if len(config.Hosts) > 0 || len(config.Patterns) > 0 {
+		rule.Hosts, rule.Patterns = config.Hosts, config.Patterns
+	}
+
+	entry := &resolverEntry{
+		Resolver: resolver,
+		name:     ResolverName(resolver),
+		hosts:    rule.Hosts,
+		priority: rule.Priority,
+	}
+
+	for _, pattern := range rule.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile pattern %s", pattern)
+		}
+
+		entry.patterns = append(entry.patterns, re)
+	}
+
+	return entry, nil
+}
+
+// matches checks if the resolver is applicable to the URL.
+// Resolvers with neither hosts nor patterns are applicable to all URLs.
+func (e *resolverEntry) matches(source *url.URL) bool {
+	if len(e.hosts) == 0 && len(e.patterns) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(source.Hostname())
+	for _, domain := range e.hosts {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	for _, pattern := range e.patterns {
+		if pattern.MatchString(source.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Impl) RegisterMediaResolver(resolver media.Resolver) error {
+	name := ResolverName(resolver)
+	config := m.Resolvers[name]
+	if config.Disabled {
+		return apfel.ErrDisabled
+	}
+
+	entry, err := newResolverEntry(resolver, config)
+	if err != nil {
+		return errors.Wrapf(err, "resolver %s", name)
+	}
+
+	m.resolvers = append(m.resolvers, entry)
+	sort.SliceStable(m.resolvers, func(i, j int) bool {
+		return m.resolvers[i].priority > m.resolvers[j].priority
+	})
+
+	return nil
+}
+
+// resolve tries all applicable resolvers in order of priority until one of them succeeds.
+// If all of them fail, the source URL is tried as a plain HTTP reference.
+func (m *Impl) resolve(ctx context.Context, source *url.URL) (media.MetaRef, *media.Meta, error) {
+	var lastErr error
+	for _, resolver := range m.resolvers {
+		if !resolver.matches(source) {
+			continue
+		}
+
+		metaRef, meta, err := m.tryResolve(ctx, resolver.name, resolver, source)
+		if metaRef == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "resolve [%s] with [%s]: %v", source, resolver, err)
+		if err == nil {
+			return metaRef, meta, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+
+		lastErr = err
+	}
+
+	if lastErr != nil && !m.HTTPFallback {
+		return nil, nil, lastErr
+	}
+
+	logf.Get(m).Debugf(ctx, "resolve [%s] as http ref", source)
+	metaRef, meta, err := m.tryResolve(ctx, "http", nil, source)
+	if err != nil && lastErr != nil {
+		// resolver errors are usually more informative
+		err = lastErr
+	}
+
+	return metaRef, meta, err
+}
+
+// resolveFile resolves file source unless it has been resolved already (like album items).
+func (m *Impl) resolveFile(ctx context.Context, file *fileRef) (media.MetaRef, *media.Meta, error) {
+	if file.resolved == nil {
+		return m.resolve(ctx, file.source)
+	}
+
+	meta, err := file.resolved.GetMeta(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get meta")
+	}
+
+	return file.resolved, meta, nil
+}
+
+// resolveGroup tries all applicable resolvers which support multiple media in order of priority.
+// It returns nil if the source does not contain several media, so that it should be mediated as usual.
+func (m *Impl) resolveGroup(ctx context.Context, source *url.URL) []media.MetaRef {
+	for _, resolver := range m.resolvers {
+		multi, ok := resolver.Resolver.(media.MultiResolver)
+		if !ok || !resolver.matches(source) {
+			continue
+		}
+
+		metaRefs, err := multi.ResolveAll(ctx, source)
+		if metaRefs == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "resolve [%s] with [%s]: %d items, %v", source, resolver, len(metaRefs), err)
+		if err == nil && len(metaRefs) > 1 {
+			labels := make(me3x.Labels, 0, 1).
+				Add("resolver", resolver.name)
+			m.Metrics.Counter("resolved_group", labels).Inc()
+			return metaRefs
+		}
+
+		// errors are handled while mediating the source as a single media
+		break
+	}
+
+	return nil
+}
+
+func (m *Impl) tryResolve(ctx context.Context, name string, resolver media.Resolver, source *url.URL) (media.MetaRef, *media.Meta, error) {
+	var (
+		metaRef media.MetaRef
+		err     error
+	)
+
+	if resolver != nil {
+		metaRef, err = resolver.Resolve(ctx, source)
+		if metaRef == nil && err == nil {
+			return nil, nil, nil
+		}
+	} else {
+		metaRef = &media.HTTPRef{URL: source.String()}
+	}
+
+	var meta *media.Meta
+	if err == nil {
+		meta, err = metaRef.GetMeta(ctx)
+		err = errors.Wrap(err, "get meta")
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+
+	labels := make(me3x.Labels, 0, 2).
+		Add("resolver", name).
+		Add("result", result)
+	m.Metrics.Counter("resolved", labels).Inc()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metaRef, meta, nil
+}
@@ -0,0 +1,79 @@
+package mediator
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+)
+
+type testResolver struct {
+	rule media.ResolverRule
+}
+
+func (r testResolver) String() string {
+	return resolverPrefix + "test"
+}
+
+func (r testResolver) ResolverRule() media.ResolverRule {
+	return r.rule
+}
+
+func (r testResolver) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
+	return nil, nil
+}
+
+func TestResolverEntryMatches(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		rule    media.ResolverRule
+		config  ResolverConfig
+		url     string
+		matches bool
+	}{
+		{name: "no rules", url: "https://example.com/video", matches: true},
+		{name: "host", rule: media.ResolverRule{Hosts: []string{"imgur.com"}}, url: "https://imgur.com/a/x", matches: true},
+		{name: "subdomain", rule: media.ResolverRule{Hosts: []string{"imgur.com"}}, url: "https://i.imgur.com/x.mp4", matches: true},
+		{name: "host case", rule: media.ResolverRule{Hosts: []string{"imgur.com"}}, url: "https://I.IMGUR.COM/x.mp4", matches: true},
+		{name: "host suffix", rule: media.ResolverRule{Hosts: []string{"imgur.com"}}, url: "https://notimgur.com/x"},
+		{name: "host port", rule: media.ResolverRule{Hosts: []string{"imgur.com"}}, url: "https://imgur.com:8443/x", matches: true},
+		{name: "pattern", rule: media.ResolverRule{Patterns: []string{`^https://example\.com/v/`}}, url: "https://example.com/v/1", matches: true},
+		{name: "pattern mismatch", rule: media.ResolverRule{Patterns: []string{`^https://example\.com/v/`}}, url: "https://example.com/p/1"},
+		{
+			name:   "config overrides hosts",
+			rule:   media.ResolverRule{Hosts: []string{"imgur.com"}},
+			config: ResolverConfig{Hosts: []string{"example.com"}},
+			url:    "https://imgur.com/a/x",
+		},
+		{
+			name:    "config patterns override hosts",
+			rule:    media.ResolverRule{Hosts: []string{"imgur.com"}},
+			config:  ResolverConfig{Patterns: []string{`/watch\?v=`}},
+			url:     "https://example.com/watch?v=1",
+			matches: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := newResolverEntry(testResolver{rule: tc.rule}, tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			source, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if matches := entry.matches(source); matches != tc.matches {
+				t.Errorf("expected %v, got %v", tc.matches, matches)
+			}
+		})
+	}
+}
+
+func TestNewResolverEntryInvalidPattern(t *testing.T) {
+	if _, err := newResolverEntry(testResolver{}, ResolverConfig{Patterns: []string{"("}}); err == nil {
+		t.Error("expected error")
+	}
+}
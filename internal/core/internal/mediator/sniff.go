@@ -0,0 +1,404 @@
+package mediator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/core/internal/blobs"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// animatedVariant is appended to MIME type of animated images.
+	animatedVariant = "+animated"
+	// alphaVariant is appended to MIME type of still images with transparency.
+	alphaVariant = "+alpha"
+)
+
+// getVariantType returns MIME type with variant suffix (like image/gif+animated)
+// for media types whose conversion depends on the content.
+func getVariantType(ctx context.Context, ref media.Ref, mimeType string) (string, error) {
+	switch mimeType {
+	case "image/gif":
+		animated, err := sniff(ctx, ref, isAnimatedGIF)
+		if err != nil || !animated {
+			return mimeType, err
+		}
+
+		return mimeType + animatedVariant, nil
+
+	case "image/webp":
+		flags, err := sniff(ctx, ref, getWebPFlags)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case flags&webpAnimationFlag != 0:
+			return mimeType + animatedVariant, nil
+		case flags&webpAlphaFlag != 0:
+			return mimeType + alphaVariant, nil
+		}
+	}
+
+	return mimeType, nil
+}
+
+// sniffLength is the number of leading bytes used for MIME type detection.
+const sniffLength = 512
+
+// strictTypes are MIME types which are always recognized by detectMIMEType.
+// Payloads declared as these types are rejected if their type can not be detected.
+var strictTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"image/avif":      true,
+	"image/heic":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/quicktime": true,
+}
+
+// ftypBrands maps ISO BMFF brands to MIME types for files which are not plain MP4 videos.
+var ftypBrands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+}
+
+// reconcileMIMEType detects MIME type of the media by its magic bytes and reconciles it with the declared one.
+// HTML pages (like error pages served with an image type) and unknown payloads declared as strict types are rejected.
+// Media which has not been buffered locally is not checked.
+func (m *Impl) reconcileMIMEType(ctx context.Context, meta *media.Meta, ref media.Ref) (*media.Meta, error) {
+	if strings.HasPrefix(meta.MIMEType, "text/") {
+		return nil, errors.Errorf("%s is not media", meta.MIMEType)
+	}
+
+	input, err := ref.Get(ctx)
+	switch {
+	case errors.Is(err, blobs.ErrTooLarge):
+		// the media will be compressed from the source
+		return meta, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if _, ok := input.(flu.URL); ok {
+		return meta, nil
+	}
+
+	detected, err := sniff(ctx, ref, detectMIMEType)
+	if err != nil {
+		return nil, errors.Wrap(err, "detect mime type")
+	}
+
+	switch {
+	case detected == meta.MIMEType:
+		return meta, nil
+	case strings.HasPrefix(detected, "text/"):
+		return nil, errors.Errorf("got %s payload instead of %s", detected, meta.MIMEType)
+	case detected == "":
+		if strictTypes[meta.MIMEType] {
+			return nil, errors.Errorf("unknown payload declared as %s", meta.MIMEType)
+		}
+
+		return meta, nil
+	}
+
+	logf.Get(m).Debugf(ctx, "detected %s instead of declared %s", detected, meta.MIMEType)
+	return &media.Meta{
+		MIMEType: detected,
+		Size:     meta.Size,
+		Filename: meta.Filename,
+	}, nil
+}
+
+// detectMIMEType detects MIME type by leading bytes. It returns an empty string if the type is unknown.
+func detectMIMEType(reader *bufio.Reader) (string, error) {
+	head, err := reader.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.Wrap(err, "read header")
+	}
+
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return getFtypType(head), nil
+	case bytes.HasPrefix(head, []byte("\x1A\x45\xDF\xA3")):
+		// Matroska files are converted the same way as WebM
+		return "video/webm", nil
+	case isMPEGAudio(reader, head):
+		return "audio/mpeg", nil
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "", errors.Wrap(err, "parse detected type")
+	}
+
+	switch mimeType {
+	case "application/octet-stream", "text/plain":
+		// text/plain is detected for any data without binary control bytes
+		return "", nil
+	case "application/ogg":
+		switch {
+		case bytes.Contains(head, []byte("OpusHead")):
+			// only Opus streams may be sent as voice messages
+			return "audio/ogg", nil
+		case bytes.Contains(head, []byte("\x01vorbis")):
+			return "application/ogg", nil
+		}
+
+		return "video/ogg", nil
+	}
+
+	return mimeType, nil
+}
+
+// getFtypType returns MIME type of an ISO BMFF file by its major and compatible brands.
+func getFtypType(head []byte) string {
+	size := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if size > len(head) || size < 16 {
+		size = len(head)
+	}
+
+	// major brand goes first and is followed by minor version
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+
+	for _, brand := range brands {
+		if mimeType, ok := ftypBrands[brand]; ok {
+			return mimeType
+		}
+	}
+
+	return "video/mp4"
+}
+
+// mpegBitrates are MPEG audio bitrates in kbit/s by version (1 or 2 and 2.5), layer and bitrate index.
+// Free format (index 0) is not supported.
+var mpegBitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mpegSampleRates are MPEG 1 sample rates in Hz. They are halved for MPEG 2 and quartered for MPEG 2.5.
+var mpegSampleRates = [3]int{44100, 48000, 32000}
+
+// getMPEGFrameLength returns the length of MPEG audio frame by its header.
+// It returns zero if the header is not valid.
+func getMPEGFrameLength(header []byte) int {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return 0
+	}
+
+	version := header[1] >> 3 & 0x03 // 0 is MPEG 2.5, 2 is MPEG 2, 3 is MPEG 1
+	layer := 3 - int(header[1]>>1&0x03)
+	bitrateIndex := header[2] >> 4
+	sampleRateIndex := header[2] >> 2 & 0x03
+	padding := int(header[2] >> 1 & 0x01)
+	if version == 1 || layer == 3 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		// reserved values (layer bits 00 are used by AAC ADTS headers)
+		return 0
+	}
+
+	table, sampleRate := 0, mpegSampleRates[sampleRateIndex]
+	switch version {
+	case 0:
+		table, sampleRate = 1, sampleRate/4
+	case 2:
+		table, sampleRate = 1, sampleRate/2
+	}
+
+	bitrate := mpegBitrates[table][layer][bitrateIndex] * 1000
+	switch {
+	case layer == 0:
+		return (12*bitrate/sampleRate + padding) * 4
+	case layer == 2 && version != 3:
+		return 72*bitrate/sampleRate + padding
+	default:
+		return 144*bitrate/sampleRate + padding
+	}
+}
+
+// isMPEGAudio checks if the data starts with an MPEG audio frame followed by another one with the same parameters.
+// A single frame header is not enough since frame sync bits are likely to occur in arbitrary data.
+func isMPEGAudio(reader *bufio.Reader, head []byte) bool {
+	length := getMPEGFrameLength(head)
+	if length == 0 {
+		return false
+	}
+
+	data, err := reader.Peek(length + 4)
+	if err != nil {
+		return false
+	}
+
+	next := data[length:]
+	return getMPEGFrameLength(next) > 0 &&
+		// version and layer
+		next[1]&0x1E == head[1]&0x1E &&
+		// sample rate
+		next[2]&0x0C == head[2]&0x0C
+}
+
+func sniff[T any](ctx context.Context, ref media.Ref, fn func(reader *bufio.Reader) (T, error)) (value T, err error) {
+	input, err := ref.Get(ctx)
+	if err != nil {
+		return value, err
+	}
+
+	reader, err := input.Reader()
+	if err != nil {
+		return value, errors.Wrap(err, "open blob")
+	}
+
+	defer flu.CloseQuietly(reader)
+	return fn(bufio.NewReader(reader))
+}
+
+const (
+	webpAnimationFlag = 1 << 1
+	webpAlphaFlag     = 1 << 4
+)
+
+// getWebPFlags returns VP8X feature flags of a WebP image.
+// Simple WebP images have no VP8X chunk, so only alpha flag is derived from the lossless bitstream header for them.
+func getWebPFlags(reader *bufio.Reader) (byte, error) {
+	var header [25]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, errors.Wrap(err, "read webp header")
+	}
+
+	if !bytes.Equal(header[0:4], []byte("RIFF")) || !bytes.Equal(header[8:12], []byte("WEBP")) {
+		return 0, errors.New("invalid webp header")
+	}
+
+	switch string(header[12:16]) {
+	case "VP8X":
+		return header[20], nil
+	case "VP8L":
+		// alpha_is_used bit follows 14-bit width and height in the lossless bitstream header
+		return (header[24] >> 4 & 1) * webpAlphaFlag, nil
+	default:
+		return 0, nil
+	}
+}
+
+// isAnimatedGIF checks if the GIF image contains more than one frame.
+// It scans GIF blocks without decoding any image data.
+func isAnimatedGIF(reader *bufio.Reader) (bool, error) {
+	var header [13]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return false, errors.Wrap(err, "read gif header")
+	}
+
+	if !bytes.Equal(header[0:3], []byte("GIF")) {
+		return false, errors.New("invalid gif header")
+	}
+
+	if err := skipGIFColorTable(reader, header[10]); err != nil {
+		return false, err
+	}
+
+	frames := 0
+	for {
+		introducer, err := reader.ReadByte()
+		if err != nil {
+			return false, errors.Wrap(err, "read block")
+		}
+
+		switch introducer {
+		case 0x21: // extension
+			if _, err := reader.Discard(1); err != nil {
+				return false, errors.Wrap(err, "read extension label")
+			}
+
+			if err := skipGIFSubBlocks(reader); err != nil {
+				return false, err
+			}
+
+		case 0x2c: // image descriptor
+			frames++
+			if frames > 1 {
+				return true, nil
+			}
+
+			var descriptor [9]byte
+			if _, err := io.ReadFull(reader, descriptor[:]); err != nil {
+				return false, errors.Wrap(err, "read image descriptor")
+			}
+
+			if err := skipGIFColorTable(reader, descriptor[8]); err != nil {
+				return false, err
+			}
+
+			// LZW minimum code size
+			if _, err := reader.Discard(1); err != nil {
+				return false, errors.Wrap(err, "read image data")
+			}
+
+			if err := skipGIFSubBlocks(reader); err != nil {
+				return false, err
+			}
+
+		case 0x3b: // trailer
+			return false, nil
+
+		default:
+			return false, errors.Errorf("unexpected gif block 0x%x", introducer)
+		}
+	}
+}
+
+func skipGIFColorTable(reader *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+
+	_, err := reader.Discard(3 * (1 << (flags&0x07 + 1)))
+	return errors.Wrap(err, "read color table")
+}
+
+func skipGIFSubBlocks(reader *bufio.Reader) error {
+	for {
+		size, err := reader.ReadByte()
+		if err != nil {
+			return errors.Wrap(err, "read sub-block")
+		}
+
+		if size == 0 {
+			return nil
+		}
+
+		if _, err := reader.Discard(int(size)); err != nil {
+			return errors.Wrap(err, "read sub-block")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package mediator
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func newTestWebP(chunk string, payload ...byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP" + chunk + "\x0a\x00\x00\x00")
+	data = append(data, payload...)
+	return append(data, make([]byte, 10)...)
+}
+
+func TestGetWebPFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		flags byte
+		err   bool
+	}{
+		{name: "lossy", data: newTestWebP("VP8 ", 0x9d, 0x01, 0x2a)},
+		{name: "extended animated", data: newTestWebP("VP8X", webpAnimationFlag), flags: webpAnimationFlag},
+		{name: "extended with alpha", data: newTestWebP("VP8X", webpAlphaFlag), flags: webpAlphaFlag},
+		{name: "lossless with alpha", data: newTestWebP("VP8L", 0x2f, 0, 0, 0, 0x10), flags: webpAlphaFlag},
+		{name: "lossless without alpha", data: newTestWebP("VP8L", 0x2f, 0xff, 0xff, 0xff, 0x0f)},
+		{name: "not a webp", data: bytes.Replace(newTestWebP("VP8 "), []byte("WEBP"), []byte("WAVE"), 1), err: true},
+		{name: "truncated", data: []byte("RIFF\x00\x00\x00\x00WEBP"), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flags, err := getWebPFlags(bufio.NewReader(bytes.NewReader(tc.data)))
+			switch {
+			case tc.err && err == nil:
+				t.Errorf("expected error, got flags %b", flags)
+			case !tc.err && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case flags != tc.flags:
+				t.Errorf("expected flags %b, got %b", tc.flags, flags)
+			}
+		})
+	}
+}
+
+func TestIsAnimatedGIF(t *testing.T) {
+	animated := newTestGIF(t, 3)
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		animated bool
+		err      bool
+	}{
+		{name: "single frame", data: newTestGIF(t, 1)},
+		{name: "multiple frames", data: animated, animated: true},
+		{name: "not a gif", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00"), err: true},
+		{name: "truncated", data: newTestGIF(t, 1)[:20], err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := isAnimatedGIF(bufio.NewReader(bytes.NewReader(tc.data)))
+			switch {
+			case tc.err && err == nil:
+				t.Errorf("expected error, got %v", result)
+			case !tc.err && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case result != tc.animated:
+				t.Errorf("expected %v, got %v", tc.animated, result)
+			}
+		})
+	}
+}
+
+func newTestFtyp(major string, compatible ...string) []byte {
+	size := 16 + 4*len(compatible)
+	data := []byte{0, 0, 0, byte(size)}
+	data = append(data, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, brand := range compatible {
+		data = append(data, brand...)
+	}
+
+	return append(data, "\x00\x00\x00\x08free"...)
+}
+
+// newTestMPEGFrames returns MPEG audio frames with the header and the frame length.
+func newTestMPEGFrames(header string, length, count int) []byte {
+	var data []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, length)
+		copy(frame, header)
+		data = append(data, frame...)
+	}
+
+	return data
+}
+
+func TestGetFtypType(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		mimeType string
+	}{
+		{name: "mp4", data: newTestFtyp("isom", "isom", "iso2", "avc1", "mp41"), mimeType: "video/mp4"},
+		{name: "avif", data: newTestFtyp("avif", "mif1", "miaf"), mimeType: "image/avif"},
+		{name: "heic compatible brand", data: newTestFtyp("mif1", "mif1", "heic"), mimeType: "image/heic"},
+		{name: "quicktime", data: newTestFtyp("qt  ", "qt  "), mimeType: "video/quicktime"},
+		{name: "m4a", data: newTestFtyp("M4A ", "M4A ", "mp42", "isom"), mimeType: "audio/mp4"},
+		{name: "brands outside of box are ignored", data: append(newTestFtyp("isom"), "heic"...), mimeType: "video/mp4"},
+		{name: "truncated box", data: newTestFtyp("isom", "avif")[:20], mimeType: "image/avif"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if mimeType := getFtypType(tc.data); mimeType != tc.mimeType {
+				t.Errorf("expected %s, got %s", tc.mimeType, mimeType)
+			}
+		})
+	}
+}
+
+func TestGetMPEGFrameLength(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		length int
+	}{
+		{name: "mpeg 1 layer 3", header: "\xFF\xFB\x90\x00", length: 417},
+		{name: "mpeg 1 layer 3 with padding", header: "\xFF\xFB\x92\x00", length: 418},
+		{name: "mpeg 2 layer 3", header: "\xFF\xF3\x90\x00", length: 261},
+		{name: "mpeg 1 layer 2", header: "\xFF\xFD\x90\x00", length: 522},
+		{name: "mpeg 1 layer 1", header: "\xFF\xFF\x90\x00", length: 312},
+		{name: "aac adts", header: "\xFF\xF1\x50\x80"},
+		{name: "jpeg", header: "\xFF\xD8\xFF\xE0"},
+		{name: "reserved version", header: "\xFF\xEB\x90\x00"},
+		{name: "free bitrate", header: "\xFF\xFB\x00\x00"},
+		{name: "bad bitrate", header: "\xFF\xFB\xF0\x00"},
+		{name: "reserved sample rate", header: "\xFF\xFB\x9C\x00"},
+		{name: "truncated", header: "\xFF\xFB\x90"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if length := getMPEGFrameLength([]byte(tc.header)); length != tc.length {
+				t.Errorf("expected %d, got %d", tc.length, length)
+			}
+		})
+	}
+}
+
+func TestDetectMIMEType(t *testing.T) {
+	frames := newTestMPEGFrames("\xFF\xFB\x90\x00", 417, 3)
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		mimeType string
+	}{
+		{name: "mp4", data: newTestFtyp("isom", "isom", "mp41"), mimeType: "video/mp4"},
+		{name: "heic", data: newTestFtyp("heic", "mif1", "heic"), mimeType: "image/heic"},
+		{name: "matroska", data: []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x88matroska"), mimeType: "video/webm"},
+		{name: "mp3 frames", data: frames, mimeType: "audio/mpeg"},
+		{name: "mp3 with id3", data: append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), frames...), mimeType: "audio/mpeg"},
+		{name: "single mp3 frame", data: frames[:417]},
+		{name: "frame sync in arbitrary data", data: append([]byte("\xFF\xFB\x90\x00"), bytes.Repeat([]byte{0x42}, 1024)...)},
+		{name: "next frame with another layer", data: append(newTestMPEGFrames("\xFF\xFB\x90\x00", 417, 1), newTestMPEGFrames("\xFF\xFD\x90\x00", 417, 1)...)},
+		{name: "aac adts", data: append([]byte("\xFF\xF1\x50\x80\x02\x1F\xFC"), make([]byte, 512)...)},
+		{name: "ogg opus", data: []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x13OpusHead\x01\x02"), mimeType: "audio/ogg"},
+		{name: "ogg vorbis", data: []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1E\x01vorbis\x00\x00"), mimeType: "application/ogg"},
+		{name: "ogg theora", data: []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x2A\x80theora"), mimeType: "video/ogg"},
+		{name: "html", data: []byte("<!DOCTYPE html><html><body>Not found</body></html>"), mimeType: "text/html"},
+		{name: "text", data: []byte("plain text")},
+		{name: "empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mimeType, err := detectMIMEType(bufio.NewReader(bytes.NewReader(tc.data)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if mimeType != tc.mimeType {
+				t.Errorf("expected %q, got %q", tc.mimeType, mimeType)
+			}
+		})
+	}
+}
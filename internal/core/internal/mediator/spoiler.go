@@ -0,0 +1,202 @@
+package mediator
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// executor executes raw Telegram Bot API calls.
+// telegram-bot-api does not support has_spoiler, audio and video parameters yet,
+// so media requiring them are sent via raw calls (see rawSender).
+type executor interface {
+	Execute(ctx context.Context, method string, body flu.EncoderTo, resp any) error
+}
+
+// canSpoiler checks if the media type supports spoilers.
+func canSpoiler(mediaType telegram.MediaType) bool {
+	return mediaType == telegram.Photo || mediaType == telegram.Video || mediaType == telegram.Animation
+}
+
+// thumbnailAttachment is the name of the multipart field containing video thumbnail.
+const thumbnailAttachment = "thumbnail"
+
+// send sends a single media with additional parameters.
+// thumbnail is attached if it is not nil.
+func (s *rawSender) send(ctx context.Context, chatID telegram.ChatID, payload *telegram.Media, options *telegram.SendOptions, params map[string]string, thumbnail flu.Input) (*mediaMessage, error) {
+	form, err := sendForm(httpf.FormValue(payload), chatID, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range params {
+		form = form.Set(key, value)
+	}
+
+	mediaType := string(payload.Type)
+
+	var body flu.EncoderTo
+	url, isURL := payload.Input.(flu.URL)
+	if isURL {
+		form = form.Set(mediaType, url.String())
+		body = form
+	}
+
+	if !isURL || thumbnail != nil {
+		multipart := form.Multipart()
+		if !isURL {
+			multipart = multipart.File(mediaType, mediaFilename(payload), payload.Input)
+		}
+
+		if thumbnail != nil {
+			multipart = multipart.File(thumbnailAttachment, thumbnailAttachment+".jpg", thumbnail)
+		}
+
+		body = multipart
+	}
+
+	message := new(mediaMessage)
+	method := "send" + strings.ToUpper(mediaType[:1]) + mediaType[1:]
+	if err := s.execute(ctx, chatID, method, body, message); err != nil {
+		return nil, err
+	}
+
+	s.createLock(&message.Chat)
+	return message, nil
+}
+
+type rawMedia struct {
+	Type              telegram.MediaType `json:"type"`
+	Media             string             `json:"media"`
+	Caption           string             `json:"caption,omitempty"`
+	ParseMode         telegram.ParseMode `json:"parse_mode,omitempty"`
+	HasSpoiler        bool               `json:"has_spoiler,omitempty"`
+	Thumbnail         string             `json:"thumbnail,omitempty"`
+	Duration          int                `json:"duration,omitempty"`
+	Width             int                `json:"width,omitempty"`
+	Height            int                `json:"height,omitempty"`
+	SupportsStreaming bool               `json:"supports_streaming,omitempty"`
+}
+
+// sendGroup sends a media group with parameters which are not supported by telegram-bot-api.
+// Media with spoiler flag set are covered with a spoiler, videos are sent with their attributes.
+func (s *rawSender) sendGroup(ctx context.Context, chatID telegram.ChatID, payload []telegram.Media, files []*fileRef, options *telegram.SendOptions) ([]telegram.Message, error) {
+	form, err := sendForm(new(httpf.Form), chatID, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var multipart *httpf.MultipartForm
+	attach := func(id, filename string, input flu.Input) string {
+		if multipart == nil {
+			multipart = form.Multipart()
+		}
+
+		multipart = multipart.File(id, filename, input)
+		return "attach://" + id
+	}
+
+	items := make([]rawMedia, len(payload))
+	for i, media := range payload {
+		file := files[i]
+		item := rawMedia{
+			Type:       media.Type,
+			Caption:    media.Caption,
+			ParseMode:  media.ParseMode,
+			HasSpoiler: file.spoiler && canSpoiler(media.Type),
+		}
+
+		if url, ok := media.Input.(flu.URL); ok {
+			item.Media = url.String()
+		} else {
+			item.Media = attach("media"+strconv.Itoa(i), mediaFilename(&media), media.Input)
+		}
+
+		if video := file.videoMeta(media.Type); video != nil {
+			item.SupportsStreaming = true
+			item.Duration = int(video.Duration.Seconds())
+			if video.Width > 0 && video.Height > 0 {
+				item.Width, item.Height = video.Width, video.Height
+			}
+
+			if video.Thumbnail != nil {
+				id := thumbnailAttachment + strconv.Itoa(i)
+				item.Thumbnail = attach(id, id+".jpg", video.Thumbnail)
+			}
+		}
+
+		items[i] = item
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal media")
+	}
+
+	var body flu.EncoderTo = form.Set("media", string(data))
+	if multipart != nil {
+		body = multipart.Set("media", string(data))
+	}
+
+	messages := make([]telegram.Message, 0)
+	if err := s.execute(ctx, chatID, "sendMediaGroup", body, &messages); err != nil {
+		return nil, err
+	}
+
+	if len(messages) > 0 {
+		s.createLock(&messages[0].Chat)
+	}
+
+	return messages, nil
+}
+
+func sendForm(form *httpf.Form, chatID telegram.ChatID, options *telegram.SendOptions) (*httpf.Form, error) {
+	form = form.Set("chat_id", chatID.String())
+	if options == nil {
+		return form, nil
+	}
+
+	if options.DisableNotification {
+		form = form.Set("disable_notification", "1")
+	}
+
+	if options.ReplyToMessageID != 0 {
+		form = form.Set("reply_to_message_id", options.ReplyToMessageID.String())
+	}
+
+	if options.ReplyMarkup != nil {
+		data, err := json.Marshal(options.ReplyMarkup)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal reply markup")
+		}
+
+		form = form.Set("reply_markup", string(data))
+	}
+
+	return form, nil
+}
+
+// mediaFilename returns the upload filename like telegram-bot-api does.
+func mediaFilename(media *telegram.Media) string {
+	if media.Filename != "" {
+		return media.Filename
+	}
+
+	var suffix string
+	switch media.Type {
+	case telegram.Animation:
+		suffix = ".gif"
+	case telegram.Video:
+		suffix = ".mp4"
+	case telegram.Audio:
+		suffix = ".mp3"
+	}
+
+	return string(media.Type) + suffix
+}
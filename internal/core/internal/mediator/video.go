@@ -0,0 +1,76 @@
+package mediator
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+)
+
+// probeVideo probes video attributes with registered probers.
+// Attributes provided with media options are used for the ones which could not be probed.
+// Media sent by URL are not probed since they are downloaded by Telegram.
+func (m *Impl) probeVideo(ctx context.Context, file *fileRef, media *receiver.Media) {
+	if _, ok := media.Input.(flu.URL); ok {
+		return
+	}
+
+	ref := syncf.Val[flu.Input]{V: media.Input}
+	for _, prober := range m.videoProbers {
+		video, err := prober.ProbeVideo(ctx, ref, media.MIMEType)
+		if video == nil && err == nil {
+			continue
+		}
+
+		logf.Get(m).Resultf(ctx, logf.Debug, logf.Warn, "probe video [%s] with [%s]: %v", file.source, prober, err)
+		if video != nil {
+			file.video = mergeVideoMeta(video, file.video)
+			return
+		}
+	}
+}
+
+// mergeVideoMeta fills attributes missing in probed video meta with the known ones.
+func mergeVideoMeta(probed, known *media.VideoMeta) *media.VideoMeta {
+	if known == nil {
+		return probed
+	}
+
+	video := *probed
+	if video.Duration <= 0 {
+		video.Duration = known.Duration
+	}
+
+	if video.Width <= 0 || video.Height <= 0 {
+		video.Width, video.Height = known.Width, known.Height
+	}
+
+	if video.Thumbnail == nil {
+		video.Thumbnail = known.Thumbnail
+	}
+
+	return &video
+}
+
+// setVideoParams sets sendVideo parameters which are not supported by telegram-bot-api.
+// The thumbnail is attached separately.
+func setVideoParams(params map[string]string, video *media.VideoMeta) {
+	params["supports_streaming"] = "true"
+	if video.Duration > 0 {
+		params["duration"] = strconv.Itoa(int(video.Duration.Seconds()))
+	}
+
+	if video.Width > 0 && video.Height > 0 {
+		params["width"] = strconv.Itoa(video.Width)
+		params["height"] = strconv.Itoa(video.Height)
+	}
+
+	if video.Thumbnail != nil {
+		params["thumbnail"] = "attach://" + thumbnailAttachment
+	}
+}
@@ -0,0 +1,184 @@
+package mediator
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+func TestMergeVideoMeta(t *testing.T) {
+	thumbnail := flu.Bytes("jpeg")
+	for _, tc := range []struct {
+		name   string
+		probed media.VideoMeta
+		known  *media.VideoMeta
+		result media.VideoMeta
+	}{
+		{
+			name:   "nothing known",
+			probed: media.VideoMeta{Duration: time.Second, Width: 640, Height: 480},
+			result: media.VideoMeta{Duration: time.Second, Width: 640, Height: 480},
+		},
+		{
+			name:   "probed take precedence",
+			probed: media.VideoMeta{Duration: time.Second, Width: 640, Height: 480, Thumbnail: thumbnail},
+			known:  &media.VideoMeta{Duration: time.Minute, Width: 1280, Height: 720},
+			result: media.VideoMeta{Duration: time.Second, Width: 640, Height: 480, Thumbnail: thumbnail},
+		},
+		{
+			name:   "missing are filled",
+			probed: media.VideoMeta{Width: 640, Thumbnail: thumbnail},
+			known:  &media.VideoMeta{Duration: time.Minute, Width: 1280, Height: 720},
+			result: media.VideoMeta{Duration: time.Minute, Width: 1280, Height: 720, Thumbnail: thumbnail},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := mergeVideoMeta(&tc.probed, tc.known)
+			if !reflect.DeepEqual(*result, tc.result) {
+				t.Errorf("expected %+v, got %+v", tc.result, *result)
+			}
+		})
+	}
+}
+
+func TestSetVideoParams(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		video  media.VideoMeta
+		params map[string]string
+	}{
+		{
+			name:   "full",
+			video:  media.VideoMeta{Duration: 90500 * time.Millisecond, Width: 1280, Height: 720, Thumbnail: flu.Bytes("jpeg")},
+			params: map[string]string{"supports_streaming": "true", "duration": "90", "width": "1280", "height": "720", "thumbnail": "attach://thumbnail"},
+		},
+		{
+			name:   "partial dimensions",
+			video:  media.VideoMeta{Width: 1280},
+			params: map[string]string{"supports_streaming": "true"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := make(map[string]string)
+			setVideoParams(params, &tc.video)
+			if !reflect.DeepEqual(params, tc.params) {
+				t.Errorf("expected %v, got %v", tc.params, params)
+			}
+		})
+	}
+}
+
+type testExecutor struct {
+	method string
+	body   flu.EncoderTo
+}
+
+func (e *testExecutor) Execute(ctx context.Context, method string, body flu.EncoderTo, resp any) error {
+	e.method, e.body = method, body
+	return nil
+}
+
+// parts returns form values and uploaded file contents sent to the executor.
+func (e *testExecutor) parts(t *testing.T) (map[string]string, map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := e.body.EncodeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	contentType, ok := e.body.(interface{ ContentType() string })
+	if !ok {
+		t.Fatalf("no content type for %T", e.body)
+	}
+
+	_, params, err := mime.ParseMediaType(contentType.ContentType())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&buf, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]string)
+	for key, value := range form.Value {
+		values[key] = value[0]
+	}
+
+	files := make(map[string]string)
+	for key := range form.File {
+		file, err := form.File[key][0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data bytes.Buffer
+		_, _ = data.ReadFrom(file)
+		_ = file.Close()
+		files[key] = data.String()
+	}
+
+	return values, files
+}
+
+func TestSendRawVideo(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input flu.Input
+		files map[string]string
+		video string
+	}{
+		{
+			name:  "upload",
+			input: flu.Bytes("mp4"),
+			files: map[string]string{"video": "mp4", "thumbnail": "jpeg"},
+		},
+		{
+			name:  "url",
+			input: flu.URL("https://example.com/video.mp4"),
+			files: map[string]string{"thumbnail": "jpeg"},
+			video: "https://example.com/video.mp4",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := make(map[string]string)
+			video := &media.VideoMeta{Duration: time.Minute, Width: 640, Height: 360, Thumbnail: flu.Bytes("jpeg")}
+			setVideoParams(params, video)
+
+			exec := new(testExecutor)
+			payload := &telegram.Media{Type: telegram.Video, Input: tc.input}
+			if _, err := getRawSender(exec).send(context.Background(), telegram.ID(1), payload, nil, params, video.Thumbnail); err != nil {
+				t.Fatal(err)
+			}
+
+			if exec.method != "sendVideo" {
+				t.Errorf("expected sendVideo, got %s", exec.method)
+			}
+
+			values, files := exec.parts(t)
+			for key, value := range params {
+				if values[key] != value {
+					t.Errorf("expected %s=%s, got %s", key, value, values[key])
+				}
+			}
+
+			if values["video"] != tc.video {
+				t.Errorf("expected video=%s, got %s", tc.video, values["video"])
+			}
+
+			if !reflect.DeepEqual(files, tc.files) {
+				t.Errorf("expected files %v, got %v", tc.files, files)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core/internal/mediator"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 
 	"github.com/jfk9w-go/flu"
@@ -298,12 +299,12 @@ func (p *Impl) refresh(ctx context.Context, sub *feed.Subscription) (int, error)
 func (p *Impl) createHTMLWriter(ctx context.Context, feedID feed.ID) *tghtml.Writer {
 	return (&tghtml.Writer{
 		Out: &output.Paged{
-			Receiver: &receiver.Chat{
+			Receiver: mediator.Chat(&receiver.Chat{
 				Sender:    p.Telegram,
 				ID:        telegram.ID(feedID),
 				Silent:    true,
 				ParseMode: telegram.HTML,
-			},
+			}),
 		},
 	}).WithContext(output.With(ctx, tghtml.DefaultMaxMessageSize*9/10, 0))
 }
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	_ "embed"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+//go:embed media_hash_index.sql
+var mediaHashIndexSQL string
+
+const mediaHashMigrationBatch = 1000
+
+// MigrateMediaHashes creates media hash segment indices and fills segments for legacy perceptual hashes.
+func (s *SQL) MigrateMediaHashes(ctx context.Context, perceptualTypes []string) error {
+	if err := s.DB.WithContext(ctx).Exec(mediaHashIndexSQL).Error; err != nil {
+		return errors.Wrap(err, "create indices")
+	}
+
+	migrated := 0
+	for {
+		var hashes []feed.MediaHash
+		if err := s.DB.WithContext(ctx).
+			Where("hash_type in ? and seg0 is null", perceptualTypes).
+			Limit(mediaHashMigrationBatch).
+			Find(&hashes).
+			Error; err != nil {
+			return errors.Wrap(err, "find legacy hashes")
+		}
+
+		if len(hashes) == 0 {
+			break
+		}
+
+		if err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for i := range hashes {
+				hash := &hashes[i]
+				values, err := feed.ParsePerceptual(hash.Value)
+				if err != nil {
+					return errors.Wrapf(err, "parse %s", hash.Value)
+				}
+
+				hash.SetPerceptual(hash.Type, values...)
+				if err := tx.Model(hash).
+					UpdateColumns(map[string]any{
+						"seg0": hash.Seg0,
+						"seg1": hash.Seg1,
+						"seg2": hash.Seg2,
+						"seg3": hash.Seg3,
+					}).
+					Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "update legacy hashes")
+		}
+
+		migrated += len(hashes)
+	}
+
+	if migrated > 0 {
+		logf.Get(ServiceID).Infof(ctx, "migrated %d legacy media hashes", migrated)
+	}
+
+	return nil
+}
+
+// findMatchingMediaHash looks up a hash equal to the passed one in other feeds of its scope,
+// or the closest similar hash within maxDistance in all feeds of its scope.
+func findMatchingMediaHash(tx *gorm.DB, hash *feed.MediaHash, maxDistance int) (*feed.MediaHash, error) {
+	if len(hash.Scope) > 0 {
+		var matches []feed.MediaHash
+		if err := tx.
+			Where("feed_id in ? and feed_id != ? and hash_type = ? and hash = ?", hash.Scope, hash.FeedID, hash.Type, hash.Value).
+			Order("first_seen").
+			Limit(1).
+			Find(&matches).
+			Error; err != nil {
+			return nil, errors.Wrap(err, "find exact in scope")
+		}
+
+		if len(matches) > 0 {
+			return &matches[0], nil
+		}
+	}
+
+	if values, ok := hash.Perceptual(); ok && maxDistance > 0 {
+		match, err := findSimilarMediaHash(tx, hash, values, maxDistance)
+		return match, errors.Wrap(err, "find similar")
+	}
+
+	return nil, nil
+}
+
+// findSimilarMediaHash looks up the closest stored hash within maxDistance.
+// Hashes are split into feed.MediaHashSegments segments, so by pigeonhole principle at least one segment
+// of a matching hash differs from the respective segment of the passed hash by no more than
+// maxDistance / feed.MediaHashSegments bits. This allows to use indices on segment columns.
+// Segments of multi-frame hashes are taken from the first frame, and every frame must be within maxDistance.
+func findSimilarMediaHash(tx *gorm.DB, hash *feed.MediaHash, values []uint64, maxDistance int) (*feed.MediaHash, error) {
+	radius := maxDistance / feed.MediaHashSegments
+	segments := tx.Session(&gorm.Session{NewDB: true})
+	for i, segment := range hash.Segments() {
+		segments = segments.Or(segmentColumns[i]+" in ?", segmentNeighbors(segment, radius))
+	}
+
+	var candidates []feed.MediaHash
+	if err := tx.
+		Where("feed_id in ? and hash_type = ? and hash != ?", append([]feed.ID{hash.FeedID}, hash.Scope...), hash.Type, hash.Value).
+		Where(segments).
+		Find(&candidates).
+		Error; err != nil {
+		return nil, err
+	}
+
+	var (
+		match    *feed.MediaHash
+		distance int
+	)
+
+	for i := range candidates {
+		candidate := &candidates[i]
+		candidateValues, ok := candidate.Perceptual()
+		if !ok {
+			continue
+		}
+
+		candidateDistance, ok := feed.PerceptualDistance(values, candidateValues)
+		switch {
+		case !ok:
+			continue
+		case candidateDistance > maxDistance:
+			continue
+		case match == nil,
+			candidateDistance < distance,
+			candidateDistance == distance && candidate.FirstSeen.Before(match.FirstSeen):
+			match, distance = candidate, candidateDistance
+		}
+	}
+
+	return match, nil
+}
+
+var segmentColumns = [feed.MediaHashSegments]string{"seg0", "seg1", "seg2", "seg3"}
+
+// segmentNeighbors returns all 16-bit values which differ from segment in no more than radius bits.
+func segmentNeighbors(segment int64, radius int) []int64 {
+	neighbors := []int64{segment}
+	var flip func(value int64, from, left int)
+	flip = func(value int64, from, left int) {
+		if left == 0 {
+			return
+		}
+
+		for bit := from; bit < 16; bit++ {
+			neighbor := value ^ (1 << bit)
+			neighbors = append(neighbors, neighbor)
+			flip(neighbor, bit+1, left-1)
+		}
+	}
+
+	flip(segment, 0, radius)
+	return neighbors
+}
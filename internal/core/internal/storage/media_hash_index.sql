@@ -0,0 +1,11 @@
+create index if not exists blob_seg0_idx on blob (feed_id, hash_type, seg0)
+    where seg0 is not null;
+
+create index if not exists blob_seg1_idx on blob (feed_id, hash_type, seg1)
+    where seg1 is not null;
+
+create index if not exists blob_seg2_idx on blob (feed_id, hash_type, seg2)
+    where seg2 is not null;
+
+create index if not exists blob_seg3_idx on blob (feed_id, hash_type, seg3)
+    where seg3 is not null;
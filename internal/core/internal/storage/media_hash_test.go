@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+
+	"github.com/jfk9w-go/flu/syncf"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testHashType      = "dhash"
+	testVideoHashType = "vdhash64"
+)
+
+func newTestSQL(t *testing.T) *SQL {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql db: %v", err)
+	}
+
+	// every connection to an in-memory database opens a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	if err := db.AutoMigrate(new(feed.MediaHash)); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	s := &SQL{Clock: syncf.DefaultClock, DB: db}
+	if err := s.MigrateMediaHashes(context.Background(), []string{testHashType, testVideoHashType}); err != nil {
+		t.Fatalf("migrate media hashes: %v", err)
+	}
+
+	return s
+}
+
+func newTestHash(feedID feed.ID, url string, value uint64, scope ...feed.ID) *feed.MediaHash {
+	now := time.Now()
+	hash := &feed.MediaHash{
+		FeedID:    feedID,
+		URL:       url,
+		FirstSeen: now,
+		LastSeen:  now,
+		Scope:     scope,
+	}
+
+	hash.SetPerceptual(testHashType, value)
+	return hash
+}
+
+func newTestVideoHash(feedID feed.ID, url string, values ...uint64) *feed.MediaHash {
+	hash := newTestHash(feedID, url, 0)
+	hash.SetPerceptual(testVideoHashType, values...)
+	return hash
+}
+
+func TestSegmentNeighbors(t *testing.T) {
+	for _, tc := range []struct {
+		radius int
+		count  int
+	}{
+		{radius: 0, count: 1},
+		{radius: 1, count: 1 + 16},
+		{radius: 2, count: 1 + 16 + 120},
+	} {
+		neighbors := segmentNeighbors(0x1234, tc.radius)
+		unique := make(map[int64]bool)
+		for _, neighbor := range neighbors {
+			if neighbor < 0 || neighbor > 0xffff {
+				t.Errorf("radius %d: neighbor %x is out of range", tc.radius, neighbor)
+			}
+
+			unique[neighbor] = true
+		}
+
+		if len(unique) != tc.count || len(neighbors) != tc.count {
+			t.Errorf("radius %d: expected %d unique neighbors, got %d of %d", tc.radius, tc.count, len(unique), len(neighbors))
+		}
+	}
+}
+
+func TestIsMediaUnique(t *testing.T) {
+	const (
+		maxDistance = 3
+		value       = uint64(0x0123456789abcdef)
+	)
+
+	for _, tc := range []struct {
+		name       string
+		stored     []*feed.MediaHash
+		hash       *feed.MediaHash
+		unique     bool
+		matchedURL string
+	}{
+		{
+			name:   "new hash",
+			hash:   newTestHash(1, "b", value),
+			unique: true,
+		},
+		{
+			name:       "exact duplicate",
+			stored:     []*feed.MediaHash{newTestHash(1, "a", value)},
+			hash:       newTestHash(1, "b", value),
+			matchedURL: "a",
+		},
+		{
+			name:       "near duplicate within a single segment",
+			stored:     []*feed.MediaHash{newTestHash(1, "a", value)},
+			hash:       newTestHash(1, "b", value^0b111),
+			matchedURL: "a",
+		},
+		{
+			name:       "near duplicate across segments",
+			stored:     []*feed.MediaHash{newTestHash(1, "a", value)},
+			hash:       newTestHash(1, "b", value^(1|1<<16|1<<32)),
+			matchedURL: "a",
+		},
+		{
+			name:       "closest near duplicate",
+			stored:     []*feed.MediaHash{newTestHash(1, "a", value^0b11), newTestHash(1, "c", value^0b1)},
+			hash:       newTestHash(1, "b", value),
+			matchedURL: "c",
+		},
+		{
+			name:   "too distant",
+			stored: []*feed.MediaHash{newTestHash(1, "a", value)},
+			hash:   newTestHash(1, "b", value^0b1111),
+			unique: true,
+		},
+		{
+			name:   "other feed",
+			stored: []*feed.MediaHash{newTestHash(2, "a", value)},
+			hash:   newTestHash(1, "b", value),
+			unique: true,
+		},
+		{
+			name:       "exact duplicate in scope",
+			stored:     []*feed.MediaHash{newTestHash(2, "a", value)},
+			hash:       newTestHash(1, "b", value, 2),
+			matchedURL: "a",
+		},
+		{
+			name:       "near duplicate in scope",
+			stored:     []*feed.MediaHash{newTestHash(2, "a", value)},
+			hash:       newTestHash(1, "b", value^1<<63, 2),
+			matchedURL: "a",
+		},
+		{
+			name:       "video near duplicate",
+			stored:     []*feed.MediaHash{newTestVideoHash(1, "a", value, ^value, value, ^value)},
+			hash:       newTestVideoHash(1, "b", value^0b111, ^value^0b111<<20, value^0b111<<40, ^value^0b111<<60),
+			matchedURL: "a",
+		},
+		{
+			name:   "video with a distant frame",
+			stored: []*feed.MediaHash{newTestVideoHash(1, "a", value, ^value, value, ^value)},
+			hash:   newTestVideoHash(1, "b", value, ^value, value, ^value^0b1111),
+			unique: true,
+		},
+		{
+			name:   "video with different number of frames",
+			stored: []*feed.MediaHash{newTestVideoHash(1, "a", value, ^value)},
+			hash:   newTestVideoHash(1, "b", value, ^value, value, ^value),
+			unique: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestSQL(t)
+			for _, stored := range tc.stored {
+				if err := s.DB.Create(stored).Error; err != nil {
+					t.Fatalf("store %s: %v", stored.URL, err)
+				}
+			}
+
+			unique, err := s.IsMediaUnique(ctx, tc.hash, maxDistance)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if unique != tc.unique {
+				t.Errorf("expected unique = %v, got %v", tc.unique, unique)
+			}
+
+			if tc.matchedURL != "" && tc.hash.MatchedURL.String != tc.matchedURL {
+				t.Errorf("expected matched url %s, got %s", tc.matchedURL, tc.hash.MatchedURL.String)
+			}
+		})
+	}
+}
+
+// TestIsMediaUniqueConcurrentNearDuplicate checks that a near duplicate which has been saved
+// by a concurrent mediation right before it is saved is reported as a duplicate instead of an error.
+func TestIsMediaUniqueConcurrentNearDuplicate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQL(t)
+	if _, err := s.IsMediaUnique(ctx, newTestHash(1, "a", 0), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := newTestHash(1, "b", 1)
+	concurrent := false
+	if err := s.DB.Callback().Create().Before("gorm:create").Register("test:concurrent", func(db *gorm.DB) {
+		if dest, ok := db.Statement.Dest.(*feed.MediaHash); !ok || dest != hash || concurrent {
+			return
+		}
+
+		concurrent = true
+		if _, err := db.Statement.ConnPool.ExecContext(ctx,
+			"insert into blob (feed_id, url, hash_type, hash, first_seen, last_seen, collisions, seg0, seg1, seg2, seg3) "+
+				"values (?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?)",
+			hash.FeedID, "c", hash.Type, hash.Value, hash.FirstSeen, hash.LastSeen,
+			hash.Seg0, hash.Seg1, hash.Seg2, hash.Seg3); err != nil {
+			t.Errorf("insert concurrent hash: %v", err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	unique, err := s.IsMediaUnique(ctx, hash, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !concurrent {
+		t.Fatal("concurrent hash has not been inserted")
+	}
+
+	if unique {
+		t.Error("expected duplicate")
+	}
+
+	stored, err := s.GetMediaHash(ctx, hash.FeedID, hash.Type, hash.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Collisions != 2 || stored.URL != "b" || stored.MatchedURL.String != "c" {
+		t.Errorf("unexpected stored hash: collisions = %d, url = %s, matched url = %s",
+			stored.Collisions, stored.URL, stored.MatchedURL.String)
+	}
+}
+
+func TestGetMediaHash(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQL(t)
+	for _, hash := range []*feed.MediaHash{
+		newTestHash(1, "a", 0xabc),
+		newTestHash(1, "b", 0xabcd),
+		newTestVideoHash(1, "c", 1, 2, 3, 4),
+		newTestVideoHash(1, "d", 1, 3, 3, 4),
+	} {
+		if err := s.DB.Create(hash).Error; err != nil {
+			t.Fatalf("store %s: %v", hash.URL, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, hashType, value, url string
+	}{
+		{name: "short value", hashType: testHashType, value: "abc", url: "a"},
+		{name: "full value", hashType: testVideoHashType, value: "0000000000000001000000000000000200000000000000030000000000000004", url: "c"},
+		{name: "value key", hashType: testVideoHashType, value: "00000000000000010000000000000002", url: "c"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := s.GetMediaHash(ctx, 1, tc.hashType, tc.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if hash.URL != tc.url {
+				t.Errorf("expected url %s, got %s", tc.url, hash.URL)
+			}
+		})
+	}
+
+	if _, err := s.GetMediaHash(ctx, 1, testVideoHashType, "0000000000000001"); err != feed.ErrNotFound {
+		t.Errorf("expected not found error for a short prefix, got %v", err)
+	}
+}
+
+func TestResetMediaHash(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQL(t)
+	if _, err := s.IsMediaUnique(ctx, newTestHash(1, "a", 0), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate := newTestHash(1, "b", 0b1)
+	duplicate.Spoiler, duplicate.Document = true, true
+	if unique, err := s.IsMediaUnique(ctx, duplicate, 3); err != nil || unique {
+		t.Fatalf("expected duplicate, got unique %t: %v", unique, err)
+	}
+
+	reset, err := s.ResetMediaHash(ctx, 1, duplicate.Type, duplicate.Value)
+	if err != nil || reset != 1 {
+		t.Fatalf("expected 1 reset hash, got %d: %v", reset, err)
+	}
+
+	hash, err := s.GetMediaHash(ctx, 1, duplicate.Type, duplicate.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash.URL != "b" || hash.Collisions != 0 || hash.MatchedURL.Valid {
+		t.Errorf("expected reset hash of b, got %s with %d collisions matching %v", hash.URL, hash.Collisions, hash.MatchedURL)
+	}
+
+	if !hash.Spoiler || !hash.Document {
+		t.Errorf("expected media options to be kept, got spoiler %t, document %t", hash.Spoiler, hash.Document)
+	}
+
+	// the media is still deduplicated
+	if unique, err := s.IsMediaUnique(ctx, newTestHash(1, "c", 0b1), 0); err != nil || unique {
+		t.Errorf("expected duplicate, got unique %t: %v", unique, err)
+	}
+}
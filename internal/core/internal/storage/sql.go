@@ -12,6 +12,7 @@ import (
 	"github.com/jfk9w-go/flu/syncf"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -85,6 +86,39 @@ func (s *SQL) UpdateSubscription(ctx context.Context, header feed.Header, value
 	return tx.UpdateSubscription(header, value)
 }
 
+func (s *SQL) GetSentItemIDs(ctx context.Context, header feed.Header, itemIDs []string) (colf.Set[string], error) {
+	sentIDs := make(colf.Slice[string], 0)
+	if err := s.DB.WithContext(ctx).
+		Model(new(feed.SentItem)).
+		Where("feed_id = ? and vendor = ? and sub_id = ? and item_id in ?",
+			header.FeedID, header.Vendor, header.SubID, itemIDs).
+		Pluck("item_id", &sentIDs).
+		Error; err != nil {
+		return nil, err
+	}
+
+	set := make(colf.Set[string], len(sentIDs))
+	colf.AddAll[string](&set, sentIDs)
+	return set, nil
+}
+
+func (s *SQL) SaveSentItems(ctx context.Context, items []feed.SentItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	return s.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(items).
+		Error
+}
+
+func (s *SQL) DeleteSentItems(ctx context.Context, vendor string, until time.Time) (int64, error) {
+	tx := s.DB.WithContext(ctx).
+		Delete(new(feed.SentItem), "vendor = ? and sent_at < ?", vendor, until)
+	return tx.RowsAffected, tx.Error
+}
+
 func (s *SQL) Tx(ctx context.Context, body func(tx feed.Tx) error) error {
 	return s.tx(ctx, func(tx *gorm.DB) error { return body(&sqlTx{clock: s.Clock, db: s.DB}) })
 }
@@ -128,16 +162,60 @@ func (s *SQL) EventTx(ctx context.Context, body func(tx feed.EventTx) error) err
 	return s.tx(ctx, func(tx *gorm.DB) error { return body(&sqlTx{clock: s.Clock, db: s.DB, isPG: s.IsPG}) })
 }
 
-func (s *SQL) IsMediaUnique(ctx context.Context, hash *feed.MediaHash) (bool, error) {
+func (s *SQL) IsMediaUnique(ctx context.Context, hash *feed.MediaHash, maxDistance int) (bool, error) {
 	update := clause.Set{
 		clause.Assignment{Column: clause.Column{Name: "collisions"}, Value: gorm.Expr("blob.collisions + 1")},
+		clause.Assignment{Column: clause.Column{Name: "matched_url"}, Value: gorm.Expr("blob.url")},
 		clause.Assignment{Column: clause.Column{Name: "url"}, Value: hash.URL},
 		clause.Assignment{Column: clause.Column{Name: "hash_type"}, Value: hash.Type},
 		clause.Assignment{Column: clause.Column{Name: "hash"}, Value: hash.Value},
 		clause.Assignment{Column: clause.Column{Name: "last_seen"}, Value: hash.LastSeen},
+		clause.Assignment{Column: clause.Column{Name: "spoiler"}, Value: hash.Spoiler},
+		clause.Assignment{Column: clause.Column{Name: "document"}, Value: hash.Document},
 	}
 
 	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, ok := hash.Perceptual(); (ok && maxDistance > 0) || len(hash.Scope) > 0 {
+			var exact int64
+			if err := tx.Model(new(feed.MediaHash)).
+				Where("feed_id = ? and hash_type = ? and hash = ?", hash.FeedID, hash.Type, hash.Value).
+				Count(&exact).
+				Error; err != nil {
+				return errors.Wrap(err, "count exact")
+			}
+
+			var match *feed.MediaHash
+			if exact == 0 {
+				var err error
+				if match, err = findMatchingMediaHash(tx, hash, maxDistance); err != nil {
+					return err
+				}
+			}
+
+			if match != nil {
+				if err := tx.Model(match).
+					UpdateColumns(map[string]any{
+						"collisions": gorm.Expr("collisions + 1"),
+						"last_seen":  hash.LastSeen,
+					}).
+					Error; err != nil {
+					return errors.Wrap(err, "update similar")
+				}
+
+				hash.Collisions = 1
+				hash.MatchedURL = null.StringFrom(match.URL)
+				// the same media may have been saved by a concurrent mediation, which is a duplicate as well
+				if err := tx.
+					Clauses(gormf.OnConflictClause(hash, "primaryKey", false, update)).
+					Create(hash).
+					Error; err != nil {
+					return errors.Wrap(err, "create")
+				}
+
+				return nil
+			}
+		}
+
 		if err := tx.
 			Clauses(gormf.OnConflictClause(hash, "primaryKey", false, update)).
 			Create(hash).
@@ -160,6 +238,95 @@ func (s *SQL) IsMediaUnique(ctx context.Context, hash *feed.MediaHash) (bool, er
 	return ok, err
 }
 
+func (s *SQL) GetMediaHashes(ctx context.Context, url string) ([]feed.MediaHash, error) {
+	var hashes []feed.MediaHash
+	return hashes, s.DB.WithContext(ctx).
+		Where("url = ? or matched_url = ?", url, url).
+		Order("first_seen").
+		Find(&hashes).
+		Error
+}
+
+func (s *SQL) GetMediaHash(ctx context.Context, feedID feed.ID, hashType, value string) (*feed.MediaHash, error) {
+	tx := s.DB.WithContext(ctx).Where("feed_id = ? and hash_type = ?", feedID, hashType)
+	if len(value) == feed.MediaHashKeyLength {
+		tx = tx.Where("hash like ?", value+"%")
+	} else {
+		tx = tx.Where("hash = ?", value)
+	}
+
+	var hash feed.MediaHash
+	err := tx.Order("last_seen desc").First(&hash).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, feed.ErrNotFound
+	}
+
+	return &hash, err
+}
+
+func (s *SQL) DeleteMediaHash(ctx context.Context, feedID feed.ID, hashType, value string) (int64, error) {
+	tx := s.DB.WithContext(ctx).
+		Delete(new(feed.MediaHash), "feed_id = ? and hash_type = ? and hash = ?", feedID, hashType, value)
+	return tx.RowsAffected, tx.Error
+}
+
+func (s *SQL) ResetMediaHash(ctx context.Context, feedID feed.ID, hashType, value string) (int64, error) {
+	tx := s.DB.WithContext(ctx).
+		Model(new(feed.MediaHash)).
+		Where("feed_id = ? and hash_type = ? and hash = ?", feedID, hashType, value).
+		UpdateColumns(map[string]any{
+			"collisions":  0,
+			"matched_url": nil,
+		})
+	return tx.RowsAffected, tx.Error
+}
+
+func (s *SQL) GetMostCollidedMediaHashes(ctx context.Context, feedID feed.ID, limit int) ([]feed.MediaHash, error) {
+	var hashes []feed.MediaHash
+	return hashes, s.DB.WithContext(ctx).
+		Where("feed_id = ? and collisions > 0", feedID).
+		Order("collisions desc, last_seen desc").
+		Limit(limit).
+		Find(&hashes).
+		Error
+}
+
+func (s *SQL) GetMediaFile(ctx context.Context, url string, hash *feed.MediaHash) (*feed.MediaFile, error) {
+	query := s.DB.WithContext(ctx).Where("url = ?", url)
+	if hash != nil {
+		query = query.Or("hash_type = ? and hash = ?", hash.Type, hash.Value)
+	}
+
+	var files []feed.MediaFile
+	if err := query.
+		Order("created_at desc").
+		Limit(1).
+		Find(&files).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	return &files[0], nil
+}
+
+func (s *SQL) SaveMediaFile(ctx context.Context, file *feed.MediaFile) error {
+	return s.DB.WithContext(ctx).
+		Clauses(gormf.OnConflictClause(file, "primaryKey", true, nil)).
+		Create(file).
+		Error
+}
+
+func (s *SQL) DeleteMediaFiles(ctx context.Context, fileID string) (int64, error) {
+	tx := s.DB.WithContext(ctx).
+		Delete(new(feed.MediaFile), "file_id = ?", fileID)
+	return tx.RowsAffected, tx.Error
+}
+
 func (s *SQL) tx(ctx context.Context, body func(tx *gorm.DB) error) error {
 	return s.DB.WithContext(ctx).Transaction(body)
 }
@@ -12,23 +12,48 @@ import (
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/jfk9w-go/flu/logf"
 	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
 )
 
 type MediatorConfig struct {
-	Concurrency int          `yaml:"concurrency,omitempty" doc:"How many concurrent media downloads to allow." default:"5"`
-	Timeout     flu.Duration `yaml:"timeout,omitempty" doc:"If mediation time exceeds timeout, it will be interrupted." default:"10m"`
+	Concurrency     int          `yaml:"concurrency,omitempty" doc:"How many concurrent media downloads to allow." default:"5"`
+	HostConcurrency int          `yaml:"hostConcurrency,omitempty" doc:"How many concurrent media downloads to allow per host, unless overridden in host limits." default:"3"`
+	Timeout         flu.Duration `yaml:"timeout,omitempty" doc:"If mediation time exceeds timeout, it will be interrupted." default:"10m"`
+	HashDistance    int          `yaml:"hashDistance,omitempty" doc:"Maximum Hamming distance between perceptual media hashes to consider media duplicate. Zero allows only exact matches." default:"3"`
+	Retries         int          `yaml:"retries,omitempty" doc:"How many times to retry downloads failed with temporary errors (like HTTP 429 or 5xx), unless overridden in host limits." default:"2"`
+	RetryBackoff    flu.Duration `yaml:"retryBackoff,omitempty" doc:"Delay before the first download retry. It is doubled after each attempt." default:"2s"`
+
+	HTTPFallback bool                      `yaml:"httpFallback,omitempty" doc:"Whether media URL should be downloaded directly when all applicable resolvers fail." default:"true"`
+	Resolvers    map[string]ResolverConfig `yaml:"resolvers,omitempty" doc:"Media resolver overrides by resolver name (like imgur or redgifs)."`
+
+	DedupScopes map[string][]feed.ID `yaml:"dedupScopes,omitempty" doc:"Named media deduplication scopes: keys are scope names and values are chat IDs. Media duplicates are looked up in all chats of the scopes the chat belongs to. Scope may be overridden per subscription."`
+
+	Hosts map[string]HostLimitConfig `yaml:"hosts,omitempty" doc:"Download limits by host. A host matches its subdomains as well, the most specific host is used." default:"{\"2ch.hk\":{\"concurrency\":2,\"rate\":2,\"burst\":5},\"imgur.com\":{\"concurrency\":2,\"rate\":1,\"burst\":5,\"backoff\":\"5s\"}}"`
+
+	Conversions map[string]string `yaml:"conversions,omitempty" doc:"Media conversions as source MIME type to target MIME type. Source types may have +animated (GIF, WebP) or +alpha (WebP) suffix which take precedence over plain types. Videos converted from +animated sources (like video/mp4) are sent as animations, which loop and autoplay without sound. Converters are picked by conversions they support." default:"{\"video/webm\":\"video/mp4\",\"image/avif\":\"image/jpeg\",\"image/heic\":\"image/jpeg\",\"image/gif+animated\":\"video/mp4\",\"image/webp+animated\":\"video/mp4\",\"image/webp+alpha\":\"image/png\",\"image/webp\":\"image/jpeg\"}"`
 }
 
+type (
+	ResolverConfig  = mediator.ResolverConfig
+	HostLimitConfig = mediator.HostLimitConfig
+)
+
 type MediatorService interface {
 	feed.Mediator
-	RegisterMediaResolver(resolver media.Resolver)
+	RegisterMediaResolver(resolver media.Resolver) error
 	RegisterMediaConverter(converter media.Converter)
+	RegisterMediaCompressor(compressor media.Compressor)
+	RegisterMediaHasher(hasher media.Hasher)
+	RegisterAudioProber(prober media.AudioProber)
+	RegisterVideoProber(prober media.VideoProber)
+	RegisterDuplicateListener(listener feed.DuplicateListener)
 }
 
 type MediatorContext interface {
 	apfel.PrometheusContext
 	BlobContext
 	StorageContext
+	EgressContext
 	MediatorConfig() MediatorConfig
 }
 
@@ -60,14 +85,31 @@ func (m *Mediator[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 		return err
 	}
 
+	var egress Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
 	config := app.Config().MediatorConfig()
 	mediator := &mediator.Impl{
-		Clock:   app,
-		Storage: storage,
-		Blobs:   blobs,
-		Metrics: metrics.Registry().WithPrefix("app_media"),
-		Locker:  syncf.Semaphore(app, config.Concurrency, 0),
-		Timeout: config.Timeout.Value,
+		Clock:        app,
+		Storage:      storage,
+		Files:        storage,
+		Blobs:        blobs,
+		Locker:       syncf.Semaphore(app, config.Concurrency, 0),
+		Metrics:      metrics.Registry().WithPrefix("app_media"),
+		Timeout:      config.Timeout.Value,
+		HashDistance: config.HashDistance,
+		Resolvers:    config.Resolvers,
+		HTTPFallback: config.HTTPFallback,
+		Conversions:  config.Conversions,
+		Hosts:        config.Hosts,
+		DedupScopes:  config.DedupScopes,
+		DefaultLimit: HostLimitConfig{
+			Concurrency: config.HostConcurrency,
+			Retries:     config.Retries,
+			Backoff:     config.RetryBackoff,
+		},
 	}
 
 	if err := app.Manage(ctx, mediator); err != nil {
@@ -80,13 +122,45 @@ func (m *Mediator[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 
 func (m *Mediator[C]) AfterInclude(ctx context.Context, app apfel.MixinApp[C], mixin apfel.Mixin[C]) error {
 	if resolver, ok := mixin.(media.Resolver); ok {
-		m.RegisterMediaResolver(resolver)
-		logf.Get(m).Infof(ctx, "register resolver [%s]: ok", resolver)
+		err := m.RegisterMediaResolver(resolver)
+		switch {
+		case errors.Is(err, apfel.ErrDisabled):
+			logf.Get(m).Infof(ctx, "resolver [%s] is disabled", resolver)
+		case err != nil:
+			return err
+		default:
+			logf.Get(m).Infof(ctx, "register resolver [%s]: ok", resolver)
+		}
 	}
 
 	if converter, ok := mixin.(media.Converter); ok {
 		m.RegisterMediaConverter(converter)
-		logf.Get(m).Infof(ctx, "register converter [%s]: ok", converter)
+		logf.Get(m).Infof(ctx, "register converter [%s] for %v: ok", converter, converter.Conversions())
+	}
+
+	if compressor, ok := mixin.(media.Compressor); ok {
+		m.RegisterMediaCompressor(compressor)
+		logf.Get(m).Infof(ctx, "register compressor [%s]: ok", compressor)
+	}
+
+	if hasher, ok := mixin.(media.Hasher); ok {
+		m.RegisterMediaHasher(hasher)
+		logf.Get(m).Infof(ctx, "register hasher [%s]: ok", hasher)
+	}
+
+	if prober, ok := mixin.(media.AudioProber); ok {
+		m.RegisterAudioProber(prober)
+		logf.Get(m).Infof(ctx, "register audio prober [%s]: ok", prober)
+	}
+
+	if prober, ok := mixin.(media.VideoProber); ok {
+		m.RegisterVideoProber(prober)
+		logf.Get(m).Infof(ctx, "register video prober [%s]: ok", prober)
+	}
+
+	if listener, ok := mixin.(feed.DuplicateListener); ok {
+		m.RegisterDuplicateListener(listener)
+		logf.Get(m).Infof(ctx, "register duplicate listener [%s]: ok", listener)
 	}
 
 	return nil
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 
+	"github.com/jfk9w/hikkabot/v4/internal/core/internal/mediator"
 	"github.com/jfk9w/hikkabot/v4/internal/core/internal/storage"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 
@@ -16,6 +17,7 @@ type StorageService interface {
 	feed.Storage
 	feed.EventStorage
 	feed.MediaHashStorage
+	feed.MediaFileStorage
 }
 
 type StorageContext interface {
@@ -46,15 +48,26 @@ func (s *Storage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return err
 	}
 
-	if err := db.DB().AutoMigrate(new(feed.Subscription), new(feed.Event), new(feed.MediaHash)); err != nil {
+	if err := db.DB().AutoMigrate(
+		new(feed.Subscription),
+		new(feed.Event),
+		new(feed.MediaHash),
+		new(feed.SentItem),
+		new(feed.MediaFile),
+	); err != nil {
 		return errors.Wrap(err, "auto-migrate")
 	}
 
-	s.StorageService = &storage.SQL{
+	sql := &storage.SQL{
 		Clock: app,
 		DB:    db.DB().Debug(),
 		IsPG:  db.Config.Driver == "postgres",
 	}
 
+	if err := sql.MigrateMediaHashes(ctx, mediator.PerceptualHashTypes); err != nil {
+		return errors.Wrap(err, "migrate media hashes")
+	}
+
+	s.StorageService = sql
 	return nil
 }
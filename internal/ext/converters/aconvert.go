@@ -12,7 +12,9 @@ import (
 )
 
 var aconvertFormats = map[string]string{
-	"video/mp4": "mp4",
+	"video/mp4":  "mp4",
+	"image/jpeg": "jpg",
+	"image/png":  "png",
 }
 
 type Aconvert[C aconvert.Context] struct {
@@ -33,8 +35,17 @@ func (c *Aconvert[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 	return nil
 }
 
-func (c *Aconvert[C]) Convert(ctx context.Context, ref media.Ref, mimeType string) (media.MetaRef, error) {
-	format, ok := aconvertFormats[mimeType]
+func (c *Aconvert[C]) Conversions() []media.Conversion {
+	conversions := make([]media.Conversion, 0, len(aconvertFormats))
+	for mimeType := range aconvertFormats {
+		conversions = append(conversions, media.Conversion{From: media.AnyType, To: mimeType})
+	}
+
+	return conversions
+}
+
+func (c *Aconvert[C]) Convert(ctx context.Context, ref media.Ref, conversion media.Conversion) (media.MetaRef, error) {
+	format, ok := aconvertFormats[conversion.To]
 	if !ok {
 		return nil, nil
 	}
@@ -2,12 +2,16 @@ package converters
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 
 	"github.com/jfk9w/hikkabot/v4/internal/core"
-	"github.com/jfk9w/hikkabot/v4/internal/feed"
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+	"github.com/jfk9w/hikkabot/v4/internal/util"
 
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
@@ -19,17 +23,89 @@ import (
 
 const ffmpegServiceID = "media-converters.ffmpeg"
 
-type ffmpegFormat struct {
-	f, vc, ac string
+// FFmpegFormatConfig describes ffmpeg output settings for a target MIME type.
+type FFmpegFormatConfig struct {
+	Format     string            `yaml:"format" doc:"Output container format (-f)."`
+	VideoCodec string            `yaml:"videoCodec,omitempty" doc:"Video (or image) codec (-c:v)."`
+	AudioCodec string            `yaml:"audioCodec,omitempty" doc:"Audio codec (-c:a)."`
+	Preset     string            `yaml:"preset,omitempty" doc:"Encoder preset (-preset)."`
+	CRF        int               `yaml:"crf,omitempty" doc:"Constant rate factor for video codecs (-crf). Lower values mean better quality."`
+	QScale     int               `yaml:"qscale,omitempty" doc:"Quality scale for image codecs (-q:v). Lower values mean better quality."`
+	Args       map[string]string `yaml:"args,omitempty" doc:"Additional output arguments (without leading dash)."`
 }
 
-var ffmpegFormats = map[string]ffmpegFormat{
-	"video/mp4": {"mp4", "libx264", "aac"},
+// kwargs returns ffmpeg output arguments for the format.
+func (f FFmpegFormatConfig) kwargs() ffmpeg.KwArgs {
+	args := ffmpeg.KwArgs{"f": f.Format}
+	for key, value := range f.Args {
+		args[key] = value
+	}
+
+	if f.VideoCodec != "" {
+		args["c:v"] = f.VideoCodec
+	}
+
+	if f.AudioCodec != "" {
+		args["c:a"] = f.AudioCodec
+	}
+
+	if f.Preset != "" {
+		args["preset"] = f.Preset
+	}
+
+	if f.CRF > 0 {
+		args["crf"] = f.CRF
+	}
+
+	if f.QScale > 0 {
+		args["q:v"] = f.QScale
+	}
+
+	return args
+}
+
+// ffmpegPipeArgs contains arguments by output format which are required for writing output to a non-seekable pipe.
+var ffmpegPipeArgs = map[string]ffmpeg.KwArgs{
+	"mp4":    {"movflags": "+frag_keyframe+empty_moov+default_base_moof"},
+	"image2": {"f": "image2pipe"},
+}
+
+const (
+	// ffmpegThumbnailSize and ffmpegThumbnailMaxSize are Telegram limits for video thumbnails.
+	ffmpegThumbnailSize    = 320
+	ffmpegThumbnailMaxSize = 200 << 10
+
+	// ffmpegCompressTarget is the ratio of size limit used for bitrate calculation.
+	// It leaves room for container overhead and encoder bitrate fluctuations.
+	ffmpegCompressTarget   = 0.9
+	ffmpegCompressAttempts = 2
+)
+
+type FFmpegConfig struct {
+	// GIF and WebP animations may have odd dimensions and palette pixel formats which are not supported by H.264 players,
+	// hence the default video filter and pixel format.
+	Formats map[string]FFmpegFormatConfig `yaml:"formats,omitempty" doc:"Output settings by target MIME type. Conversions to these types are declared as supported." default:"{\"video/mp4\":{\"format\":\"mp4\",\"videoCodec\":\"libx264\",\"audioCodec\":\"aac\",\"preset\":\"medium\",\"crf\":23,\"args\":{\"vf\":\"scale=trunc(iw/2)*2:trunc(ih/2)*2\",\"pix_fmt\":\"yuv420p\",\"movflags\":\"+faststart\"}},\"image/jpeg\":{\"format\":\"image2\",\"videoCodec\":\"mjpeg\",\"qscale\":2,\"args\":{\"frames:v\":\"1\"}},\"image/png\":{\"format\":\"image2\",\"videoCodec\":\"png\",\"args\":{\"frames:v\":\"1\"}}}"`
+
+	Compress struct {
+		Enabled         bool   `yaml:"enabled,omitempty" doc:"Whether videos exceeding upload size limit should be transcoded to fit into it. Requires ffprobe to be present in $PATH." default:"true"`
+		MaxHeight       int    `yaml:"maxHeight,omitempty" doc:"Maximum height of transcoded videos. Zero keeps the original resolution." default:"720"`
+		AudioBitrate    int    `yaml:"audioBitrate,omitempty" doc:"Audio bitrate of transcoded videos, kbit/s." default:"96"`
+		MinVideoBitrate int    `yaml:"minVideoBitrate,omitempty" doc:"Minimum video bitrate of transcoded videos, kbit/s. Videos which do not fit into size limit with this bitrate are dropped." default:"300"`
+		Preset          string `yaml:"preset,omitempty" doc:"libx264 preset used for transcoding." enum:"ultrafast,superfast,veryfast,faster,fast,medium,slow,slower,veryslow" default:"veryfast"`
+	} `yaml:"compress,omitempty" doc:"Size-targeted video transcoding settings."`
 }
 
-type FFmpeg[C core.BlobContext] struct {
-	clock syncf.Clock
-	blobs feed.Blobs
+type FFmpegContext interface {
+	core.BlobContext
+	FFmpegConfig() FFmpegConfig
+}
+
+type FFmpeg[C FFmpegContext] struct {
+	clock    syncf.Clock
+	blobs    *core.Blobs[C]
+	compress bool
+	probe    bool
+	config   FFmpegConfig
 }
 
 func (c FFmpeg[C]) String() string {
@@ -43,6 +119,12 @@ func (c *FFmpeg[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return apfel.ErrDisabled
 	}
 
+	config := app.Config().FFmpegConfig()
+	_, err = exec.LookPath("ffprobe")
+	logf.Get(c).Resultf(ctx, logf.Info, logf.Warn, "check ffprobe in $PATH: %v", err)
+	c.probe = err == nil
+	c.compress = c.probe && config.Compress.Enabled
+
 	var blobs core.Blobs[C]
 	if err := app.Use(ctx, &blobs, false); err != nil {
 		return err
@@ -50,43 +132,177 @@ func (c *FFmpeg[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 
 	c.clock = app
 	c.blobs = &blobs
+	c.config = config
 	return nil
 }
 
-func (c *FFmpeg[C]) Convert(ctx context.Context, ref media.Ref, mimeType string) (media.MetaRef, error) {
-	format, ok := ffmpegFormats[mimeType]
+func (c *FFmpeg[C]) Conversions() []media.Conversion {
+	conversions := make([]media.Conversion, 0, len(c.config.Formats))
+	for mimeType := range c.config.Formats {
+		conversions = append(conversions, media.Conversion{From: media.AnyType, To: mimeType})
+	}
+
+	return conversions
+}
+
+func (c *FFmpeg[C]) Convert(ctx context.Context, ref media.Ref, conversion media.Conversion) (media.MetaRef, error) {
+	format, ok := c.config.Formats[conversion.To]
 	if !ok {
 		return nil, nil
 	}
 
-	input, err := ref.Get(ctx)
+	source, err := util.GetFFmpegSource(ctx, c.blobs, ref, "")
 	if err != nil {
 		return nil, err
 	}
 
-	var stream *ffmpeg.Stream
-	switch input := input.(type) {
-	case flu.File:
-		stream = ffmpeg.Input(input.String())
-	case flu.URL:
-		stream = ffmpeg.Input(input.String())
-	default:
-		input, err := c.blobs.Buffer("", syncf.Val[flu.Input]{V: input}).Get(ctx)
+	return c.run(ctx, source, conversion.To, ffmpeg.KwArgs{"c": "copy"}, format.kwargs())
+}
+
+// Compress transcodes a video to the bitrate which allows it to fit into maxSize.
+func (c *FFmpeg[C]) Compress(ctx context.Context, ref media.Ref, mimeType string, maxSize media.Size) (media.MetaRef, error) {
+	if !c.compress || !strings.HasPrefix(mimeType, "video/") {
+		return nil, nil
+	}
+
+	source, err := util.GetFFmpegSource(core.SkipSizeCheck(ctx), c.blobs, ref, "")
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := util.ProbeDuration(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "probe duration")
+	}
+
+	config := c.config.Compress
+	format, ok := c.config.Formats["video/mp4"]
+	if !ok {
+		return nil, errors.New("video/mp4 format is not configured")
+	}
+
+	scale := "scale=trunc(iw/2)*2:trunc(ih/2)*2"
+	if config.MaxHeight > 0 {
+		scale = fmt.Sprintf("scale=-2:'min(%d,trunc(ih/2)*2)'", config.MaxHeight)
+	}
+
+	target := float64(maxSize) * ffmpegCompressTarget
+	for i := 0; i < ffmpegCompressAttempts; i++ {
+		videoBitrate := int(target*8/duration/1000) - config.AudioBitrate
+		if videoBitrate < config.MinVideoBitrate {
+			return nil, errors.Errorf("video bitrate %dk is too low for %.1fs video", videoBitrate, duration)
+		}
+
+		bitrate := fmt.Sprintf("%dk", videoBitrate)
+		args := format.kwargs()
+		// bitrate is targeted instead of constant quality
+		delete(args, "crf")
+		metaRef, err := c.run(ctx, source, "video/mp4", args, ffmpeg.KwArgs{
+			"b:v":     bitrate,
+			"maxrate": bitrate,
+			"bufsize": fmt.Sprintf("%dk", 2*videoBitrate),
+			"b:a":     fmt.Sprintf("%dk", config.AudioBitrate),
+			"preset":  config.Preset,
+			"vf":      scale,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := metaRef.GetMeta(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		file, ok := input.(flu.File)
-		if !ok {
-			return nil, errors.Errorf("only flu.File blobs are supported, got %T", input)
+		if meta.Size <= maxSize {
+			return metaRef, nil
 		}
 
-		stream = ffmpeg.Input(file.String())
+		target *= float64(maxSize) / float64(meta.Size) * ffmpegCompressTarget
 	}
 
-	blob := c.blobs.Buffer(mimeType, syncf.Val[flu.Input]{V: make(flu.Bytes, 0)})
+	return nil, errors.Errorf("unable to fit video into %s", maxSize)
+}
+
+// ProbeAudio probes audio duration, title and performer.
+func (c *FFmpeg[C]) ProbeAudio(ctx context.Context, ref media.Ref, mimeType string) (*media.AudioMeta, error) {
+	if !c.probe || !strings.HasPrefix(mimeType, "audio/") {
+		return nil, nil
+	}
+
+	source, err := util.GetFFmpegSource(core.SkipSizeCheck(ctx), c.blobs, ref, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ProbeAudio(source)
+}
+
+// ProbeVideo probes video duration and dimensions and extracts a thumbnail from the first frame.
+func (c *FFmpeg[C]) ProbeVideo(ctx context.Context, ref media.Ref, mimeType string) (*media.VideoMeta, error) {
+	if !c.probe || !strings.HasPrefix(mimeType, "video/") {
+		return nil, nil
+	}
+
+	source, err := util.GetFFmpegSource(core.SkipSizeCheck(ctx), c.blobs, ref, "")
+	if err != nil {
+		return nil, err
+	}
+
+	video, err := util.ProbeVideo(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// thumbnail is optional
+	thumbnail, err := c.thumbnail(ctx, source)
+	logf.Get(c).Resultf(ctx, logf.Trace, logf.Warn, "extract thumbnail from [%s]: %v", source, err)
+	if err == nil {
+		video.Thumbnail = thumbnail
+	}
+
+	return video, nil
+}
+
+// thumbnail extracts the first video frame as a JPEG image fitting into Telegram thumbnail limits.
+func (c *FFmpeg[C]) thumbnail(ctx context.Context, source util.FFmpegSource) (flu.Bytes, error) {
+	size := strconv.Itoa(ffmpegThumbnailSize)
+	reader, err := util.FFmpegOutput{
+		Context: ctx,
+		Source:  source,
+		Stream: source.Stream().Output("pipe:", ffmpeg.KwArgs{
+			"f":        "image2pipe",
+			"c:v":      "mjpeg",
+			"q:v":      5,
+			"frames:v": 1,
+			"vf":       "scale=" + size + ":" + size + ":force_original_aspect_ratio=decrease",
+		}),
+	}.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(reader)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(data) == 0:
+		return nil, errors.New("empty output")
+	case len(data) > ffmpegThumbnailMaxSize:
+		return nil, errors.Errorf("size %s too large", media.Size(len(data)))
+	}
+
+	return data, nil
+}
+
+func (c *FFmpeg[C]) run(ctx context.Context, source util.FFmpegSource, mimeType string, args ...ffmpeg.KwArgs) (media.MetaRef, error) {
 	ctx = core.SkipSizeCheck(ctx)
+	if !c.blobs.Local() {
+		return c.pipe(ctx, source, mimeType, args...)
+	}
 
+	blob := c.blobs.Buffer(mimeType, syncf.Val[flu.Input]{V: make(flu.Bytes, 0)})
 	output, err := blob.Get(ctx)
 	if err != nil {
 		return nil, err
@@ -98,18 +314,11 @@ func (c *FFmpeg[C]) Convert(ctx context.Context, ref media.Ref, mimeType string)
 	}
 
 	startTime := c.clock.Now()
-	stream = stream.Output(file.String(), ffmpeg.KwArgs{
-		"c":   "copy",
-		"f":   format.f,
-		"c:v": format.vc,
-		"c:a": format.ac,
-	})
-
-	stream.Context = ctx
-	err = stream.OverWriteOutput().Run()
+	stream := source.Stream().Output(file.String(), args...)
+	err = source.Run(ctx, stream.OverWriteOutput())
 	logf.Get(c).Resultf(ctx, logf.Debug, logf.Warn,
 		"convert [%s] => [%s] in %s: %v",
-		flu.Readable(input), output, c.clock.Now().Sub(startTime), err)
+		source, output, c.clock.Now().Sub(startTime), err)
 	if err != nil {
 		return nil, err
 	}
@@ -127,3 +336,29 @@ func (c *FFmpeg[C]) Convert(ctx context.Context, ref media.Ref, mimeType string)
 		},
 	}, nil
 }
+
+// pipe streams ffmpeg output directly to blob storage which does not keep blobs in local files.
+func (c *FFmpeg[C]) pipe(ctx context.Context, source util.FFmpegSource, mimeType string, args ...ffmpeg.KwArgs) (media.MetaRef, error) {
+	args = append(args, ffmpegPipeArgs[c.config.Formats[mimeType].Format])
+	output := util.FFmpegOutput{
+		Context: ctx,
+		Source:  source,
+		Stream:  source.Stream().Output("pipe:", args...),
+	}
+
+	startTime := c.clock.Now()
+	blob := c.blobs.Buffer(mimeType, syncf.Val[flu.Input]{V: output})
+	meta, err := blob.GetMeta(ctx)
+	logf.Get(c).Resultf(ctx, logf.Debug, logf.Warn,
+		"convert [%s] => pipe in %s: %v",
+		source, c.clock.Now().Sub(startTime), err)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Size <= 0 {
+		return nil, errors.New("empty output")
+	}
+
+	return blob, nil
+}
@@ -0,0 +1,192 @@
+package hashers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+	"github.com/jfk9w/hikkabot/v4/internal/util"
+
+	"github.com/corona10/goimagehash"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// stillImageTypes contains image formats which are decoded with ffmpeg.
+var stillImageTypes = map[string]bool{
+	"image/avif": true,
+	"image/heic": true,
+}
+
+const (
+	// ImageHashType is a difference hash of a still image.
+	// It must be the same as the one used by the mediator so that images match regardless of their format.
+	ImageHashType = "dhash"
+
+	// VideoHashType is a composite hash of evenly spaced video frames.
+	// Each frame contributes a 64-bit difference hash, and frames are compared one by one.
+	VideoHashType = "vdhash64"
+
+	ffmpegFrames    = 4
+	ffmpegFrameSize = 32
+)
+
+type FFmpeg[C core.BlobContext] struct {
+	clock syncf.Clock
+	blobs feed.Blobs
+}
+
+func (h FFmpeg[C]) String() string {
+	return "media-hashers.ffmpeg"
+}
+
+func (h *FFmpeg[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	for _, bin := range []string{"ffmpeg", "ffprobe"} {
+		_, err := exec.LookPath(bin)
+		logf.Get(h).Resultf(ctx, logf.Info, logf.Warn, "check %s in $PATH: %v", bin, err)
+		if err != nil {
+			return apfel.ErrDisabled
+		}
+	}
+
+	var blobs core.Blobs[C]
+	if err := app.Use(ctx, &blobs, false); err != nil {
+		return err
+	}
+
+	h.clock = app
+	h.blobs = &blobs
+	return nil
+}
+
+func (h *FFmpeg[C]) Hash(ctx context.Context, ref media.Ref, mimeType string) (*media.Hash, error) {
+	switch {
+	case strings.HasPrefix(mimeType, "video/"), mimeType == "image/gif":
+		source, err := util.GetFFmpegSource(ctx, h.blobs, ref, mimeType)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.hashVideo(ctx, source)
+
+	case stillImageTypes[mimeType]:
+		source, err := util.GetFFmpegSource(ctx, h.blobs, ref, mimeType)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.hashImage(ctx, source)
+
+	default:
+		return nil, nil
+	}
+}
+
+// hashImage decodes the first frame of image formats which are not supported by Go decoders
+// and calculates the same difference hash as used for regular images.
+func (h *FFmpeg[C]) hashImage(ctx context.Context, source util.FFmpegSource) (*media.Hash, error) {
+	startTime := h.clock.Now()
+	var output bytes.Buffer
+	stream := source.Stream().
+		Output("pipe:", ffmpeg.KwArgs{
+			"f":        "image2pipe",
+			"c:v":      "png",
+			"frames:v": 1,
+		}).
+		WithOutput(&output)
+
+	err := source.Run(ctx, stream)
+	logf.Get(h).Resultf(ctx, logf.Debug, logf.Warn,
+		"decode image [%s] in %s: %v", source, h.clock.Now().Sub(startTime), err)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := png.Decode(&output)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	dhash, err := goimagehash.DifferenceHash(img)
+	if err != nil {
+		return nil, errors.Wrap(err, "get diff hash")
+	}
+
+	return &media.Hash{
+		Type:   ImageHashType,
+		Values: []uint64{dhash.GetHash()},
+	}, nil
+}
+
+func (h *FFmpeg[C]) hashVideo(ctx context.Context, source util.FFmpegSource) (*media.Hash, error) {
+	duration, err := util.ProbeDuration(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "probe duration")
+	}
+
+	startTime := h.clock.Now()
+	frames := ffmpegFrames
+	var output bytes.Buffer
+	stream := source.Stream().
+		Filter("fps", ffmpeg.Args{strconv.FormatFloat(float64(frames)/duration, 'f', 6, 64)}).
+		Filter("scale", ffmpeg.Args{strconv.Itoa(ffmpegFrameSize), strconv.Itoa(ffmpegFrameSize)}).
+		Filter("tile", ffmpeg.Args{fmt.Sprintf("%dx1", frames)}).
+		Output("pipe:", ffmpeg.KwArgs{
+			"f":        "image2pipe",
+			"c:v":      "png",
+			"frames:v": 1,
+		}).
+		WithOutput(&output)
+
+	err = source.Run(ctx, stream)
+	logf.Get(h).Resultf(ctx, logf.Debug, logf.Warn,
+		"extract frames from [%s] in %s: %v", source, h.clock.Now().Sub(startTime), err)
+	if err != nil {
+		return nil, err
+	}
+
+	tiles, err := png.Decode(&output)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode frames")
+	}
+
+	subImager, ok := tiles.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+
+	if !ok {
+		return nil, errors.Errorf("unsupported frame image type %T", tiles)
+	}
+
+	values := make([]uint64, frames)
+	bounds := tiles.Bounds()
+	width := bounds.Dx() / frames
+	for i := 0; i < frames; i++ {
+		frame := subImager.SubImage(image.Rect(
+			bounds.Min.X+i*width, bounds.Min.Y,
+			bounds.Min.X+(i+1)*width, bounds.Max.Y))
+
+		dhash, err := goimagehash.DifferenceHash(frame)
+		if err != nil {
+			return nil, errors.Wrapf(err, "hash frame %d", i)
+		}
+
+		values[i] = dhash.GetHash()
+	}
+
+	return &media.Hash{
+		Type:   VideoHashType,
+		Values: values,
+	}, nil
+}
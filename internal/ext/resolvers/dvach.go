@@ -3,7 +3,6 @@ package resolvers
 import (
 	"context"
 	"net/url"
-	"strings"
 
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/dvach"
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
@@ -30,11 +29,11 @@ func (r *Dvach[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 	return nil
 }
 
-func (r *Dvach[C]) Resolve(ctx context.Context, url *url.URL) (media.MetaRef, error) {
-	if !strings.Contains(url.Host, "2ch.hk") {
-		return nil, nil
-	}
+func (r *Dvach[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Hosts: []string{"2ch.hk"}}
+}
 
+func (r *Dvach[C]) Resolve(ctx context.Context, url *url.URL) (media.MetaRef, error) {
 	return &media.HTTPRef{
 		URL:    url.String(),
 		Client: r.client,
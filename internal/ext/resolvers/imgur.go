@@ -1,41 +1,49 @@
 package resolvers
 
 import (
-	"bufio"
 	"context"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
 
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
-	"github.com/pkg/errors"
-
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
 )
 
-var imgurRegexp = regexp.MustCompile(`.*?(<link rel="image_src"\s+href="|<meta property="og:video"\s+content=")(.*?)".*`)
+type ImgurConfig struct {
+	ClientID string `yaml:"clientId,omitempty" doc:"imgur.com API client ID. Required for resolving all album and gallery items, otherwise only album cover is resolved."`
+}
+
+type ImgurContext interface {
+	OpenGraphContext
+	ImgurConfig() ImgurConfig
+}
 
-type Imgur[C any] struct{}
+type Imgur[C ImgurContext] struct {
+	clientID    string
+	maxPageSize media.Size
+}
 
 func (r Imgur[C]) String() string {
 	return "media-resolver.imgur"
 }
 
 func (r *Imgur[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	r.clientID = app.Config().ImgurConfig().ClientID
+	r.maxPageSize = app.Config().OpenGraphConfig().MaxPageSize
 	return nil
 }
 
-func (r *Imgur[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
-	switch source.Host {
-	case "imgur.com", "www.imgur.com", "i.imgur.com", "m.imgur.com":
-	default:
-		return nil, nil
-	}
+func (r *Imgur[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Hosts: []string{"imgur.com"}}
+}
 
+func (r *Imgur[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
 	url := source.String()
 	switch {
 	case strings.Contains(url, ".gifv"):
@@ -49,27 +57,115 @@ func (r *Imgur[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef,
 		return &media.HTTPRef{URL: url}, nil
 	}
 
-	var ref media.HTTPRef
-	return &ref, httpf.GET(url).
+	if albumID := getImgurAlbumID(source); albumID != "" && r.clientID != "" {
+		metaRefs, err := r.getAlbum(ctx, albumID)
+		if err == nil && len(metaRefs) > 0 {
+			return metaRefs[0], nil
+		}
+
+		// album cover is still available via page meta tags
+		logf.Get(r).Warnf(ctx, "get album [%s]: %v", albumID, err)
+	}
+
+	page, err := getOpenGraph(ctx, source, r.maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if page == nil {
+		return nil, errors.New("not an html")
+	}
+
+	mediaURL, err := page.mediaURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media.HTTPRef{URL: mediaURL}, nil
+}
+
+func (r *Imgur[C]) ResolveAll(ctx context.Context, source *url.URL) ([]media.MetaRef, error) {
+	albumID := getImgurAlbumID(source)
+	if albumID == "" || r.clientID == "" {
+		return nil, nil
+	}
+
+	return r.getAlbum(ctx, albumID)
+}
+
+type imgurImage struct {
+	Link     string `json:"link"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Animated bool   `json:"animated"`
+	MP4      string `json:"mp4"`
+	MP4Size  int64  `json:"mp4_size"`
+}
+
+func (r *Imgur[C]) getAlbum(ctx context.Context, albumID string) ([]media.MetaRef, error) {
+	var resp struct {
+		Data []imgurImage `json:"data"`
+	}
+
+	if err := httpf.GET("https://api.imgur.com/3/album/"+albumID+"/images").
+		Header("Authorization", "Client-ID "+r.clientID).
 		Exchange(ctx, nil).
 		CheckStatus(http.StatusOK).
-		HandleFunc(func(resp *http.Response) error {
-			defer flu.CloseQuietly(resp.Body)
-			contentType := resp.Header.Get("Content-Type")
-			if strings.HasPrefix(contentType, "text/html") {
-				return errors.New("not an html")
-			}
+		DecodeBody(flu.JSON(&resp)).
+		Error(); err != nil {
+		return nil, errors.Wrap(err, "get album images")
+	}
+
+	metaRefs := make([]media.MetaRef, 0, len(resp.Data))
+	for _, image := range resp.Data {
+		ref := &media.HTTPRef{
+			URL: image.Link,
+			Meta: &media.Meta{
+				MIMEType: image.Type,
+				Size:     media.Size(image.Size),
+			},
+		}
 
-			scanner := bufio.NewScanner(resp.Body)
-			for scanner.Scan() {
-				line := scanner.Text()
-				groups := imgurRegexp.FindStringSubmatch(line)
-				if len(groups) == 3 {
-					ref.URL = groups[2]
-					return nil
-				}
+		if image.Animated && image.MP4 != "" {
+			ref.URL = image.MP4
+			ref.Meta = &media.Meta{
+				MIMEType: "video/mp4",
+				Size:     media.Size(image.MP4Size),
 			}
+		}
+
+		if ref.URL != "" {
+			metaRefs = append(metaRefs, ref)
+		}
+	}
+
+	if len(metaRefs) == 0 {
+		return nil, errors.New("album is empty")
+	}
+
+	return metaRefs, nil
+}
+
+// getImgurAlbumID extracts album ID from album (/a/...) and gallery (/gallery/...) URLs.
+// Album and gallery URLs may contain title slug before the ID, like /gallery/some-title-AbCdE.
+func getImgurAlbumID(source *url.URL) string {
+	path := strings.Trim(source.Path, "/")
+	for _, prefix := range []string{"a/", "gallery/"} {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		id := strings.TrimPrefix(path, prefix)
+		if strings.Contains(id, "/") {
+			return ""
+		}
+
+		if idx := strings.LastIndexByte(id, '-'); idx >= 0 {
+			id = id[idx+1:]
+		}
+
+		return id
+	}
 
-			return errors.New("unable to find URL")
-		}).Error()
+	return ""
 }
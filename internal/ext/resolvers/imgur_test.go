@@ -0,0 +1,34 @@
+package resolvers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGetImgurAlbumID(t *testing.T) {
+	for _, tc := range []struct {
+		url string
+		id  string
+	}{
+		{url: "https://imgur.com/a/AbCdE", id: "AbCdE"},
+		{url: "https://imgur.com/a/AbCdE/", id: "AbCdE"},
+		{url: "https://imgur.com/gallery/AbCdE", id: "AbCdE"},
+		{url: "https://imgur.com/gallery/some-title-AbCdE", id: "AbCdE"},
+		{url: "https://imgur.com/a/AbCdE/embed", id: ""},
+		{url: "https://imgur.com/AbCdE", id: ""},
+		{url: "https://i.imgur.com/AbCdE.mp4", id: ""},
+		{url: "https://imgur.com/t/funny/AbCdE", id: ""},
+		{url: "https://imgur.com/", id: ""},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			source, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if id := getImgurAlbumID(source); id != tc.id {
+				t.Errorf("expected %q, got %q", tc.id, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package resolvers
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/httpf"
+	tghtml "github.com/jfk9w-go/telegram-bot-api/ext/html"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// openGraphPriority puts OpenGraph resolver after all site-specific resolvers.
+const openGraphPriority = -100
+
+type OpenGraphConfig struct {
+	MaxPageSize media.Size `yaml:"maxPageSize,omitempty" doc:"Maximum HTML page size to parse. Media tags are usually located at the beginning of the page." pattern:"^(\\d+)([KMGT])?$" default:"1M"`
+}
+
+type OpenGraphContext interface {
+	OpenGraphConfig() OpenGraphConfig
+}
+
+// OpenGraph resolves media from web pages using OpenGraph and Twitter card meta tags or oEmbed endpoints.
+// It is applicable to all URLs, so it is tried only after all site-specific resolvers.
+type OpenGraph[C OpenGraphContext] struct {
+	maxPageSize media.Size
+}
+
+func (r OpenGraph[C]) String() string {
+	return "media-resolver.opengraph"
+}
+
+func (r *OpenGraph[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	r.maxPageSize = app.Config().OpenGraphConfig().MaxPageSize
+	return nil
+}
+
+func (r *OpenGraph[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Priority: openGraphPriority}
+}
+
+func (r *OpenGraph[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
+	if source.Scheme != "http" && source.Scheme != "https" || isMediaPath(source.Path) {
+		return nil, nil
+	}
+
+	page, err := getOpenGraph(ctx, source, r.maxPageSize)
+	if err != nil || page == nil {
+		return nil, err
+	}
+
+	mediaURL, err := page.mediaURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media.HTTPRef{URL: mediaURL}, nil
+}
+
+// isMediaPath checks if URL path has a media file extension,
+// in which case it is pointless to look for a web page there.
+func isMediaPath(urlPath string) bool {
+	mimeType := mime.TypeByExtension(path.Ext(urlPath))
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// openGraph contains media-related data extracted from web page head.
+type openGraph struct {
+	base   *url.URL
+	meta   map[string]string
+	oEmbed string
+	image  string
+}
+
+// getOpenGraph fetches the web page and parses its meta tags.
+// It returns nil if the URL does not point to an HTML page.
+// No more than maxSize bytes of the page are read.
+func getOpenGraph(ctx context.Context, source *url.URL, maxSize media.Size) (*openGraph, error) {
+	page := &openGraph{
+		base: source,
+		meta: make(map[string]string),
+	}
+
+	isHTML := false
+	if err := httpf.GET(source.String()).
+		Header("Accept", "text/html").
+		Exchange(ctx, nil).
+		CheckStatus(http.StatusOK).
+		HandleFunc(func(resp *http.Response) error {
+			defer flu.CloseQuietly(resp.Body)
+			mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			if mimeType != "text/html" && mimeType != "application/xhtml+xml" {
+				return nil
+			}
+
+			isHTML = true
+			return page.parse(io.LimitReader(resp.Body, int64(maxSize)))
+		}).
+		Error(); err != nil {
+		return nil, errors.Wrap(err, "get page")
+	}
+
+	if !isHTML {
+		return nil, nil
+	}
+
+	return page, nil
+}
+
+func (p *openGraph) parse(reader io.Reader) error {
+	tokenizer := html.NewTokenizer(reader)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return err
+			}
+
+			// page is truncated or ends without body
+			return nil
+
+		case html.EndTagToken:
+			if token := tokenizer.Token(); token.Data == "head" {
+				return nil
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				return nil
+
+			case "meta":
+				key := tghtml.Get(token.Attr, "property")
+				if key == "" {
+					key = tghtml.Get(token.Attr, "name")
+				}
+
+				key = strings.ToLower(key)
+				// only the first value is used for repeated tags
+				if _, ok := p.meta[key]; key != "" && !ok {
+					p.meta[key] = tghtml.Get(token.Attr, "content")
+				}
+
+			case "link":
+				rel := strings.ToLower(tghtml.Get(token.Attr, "rel"))
+				switch {
+				case rel == "alternate" && tghtml.Get(token.Attr, "type") == "application/json+oembed":
+					p.oEmbed = tghtml.Get(token.Attr, "href")
+				case rel == "image_src":
+					p.image = tghtml.Get(token.Attr, "href")
+				}
+			}
+		}
+	}
+}
+
+// mediaURL selects the most suitable media URL from the page.
+// Videos are preferred to images since images are usually video thumbnails.
+func (p *openGraph) mediaURL(ctx context.Context) (string, error) {
+	// og:video may point to an HTML player, so its type is checked if present
+	if videoType := p.meta["og:video:type"]; videoType == "" || strings.HasPrefix(videoType, "video/") {
+		if url := p.first("og:video:secure_url", "og:video:url", "og:video"); url != "" {
+			return p.resolve(url)
+		}
+	}
+
+	if url := p.meta["twitter:player:stream"]; url != "" {
+		return p.resolve(url)
+	}
+
+	if p.oEmbed != "" {
+		// oEmbed endpoints are optional, so their errors are not fatal
+		if url, err := p.getOEmbedURL(ctx); err == nil && url != "" {
+			return p.resolve(url)
+		}
+	}
+
+	if url := p.first("og:image:secure_url", "og:image:url", "og:image", "twitter:image"); url != "" {
+		return p.resolve(url)
+	}
+
+	if p.image != "" {
+		return p.resolve(p.image)
+	}
+
+	return "", errors.New("no media found on page")
+}
+
+func (p *openGraph) first(keys ...string) string {
+	for _, key := range keys {
+		if value := p.meta[key]; value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// getOEmbedURL returns photo URL from oEmbed endpoint.
+// Other oEmbed types contain only HTML snippets, so they are ignored.
+func (p *openGraph) getOEmbedURL(ctx context.Context) (string, error) {
+	endpoint, err := p.resolve(p.oEmbed)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+
+	if err := httpf.GET(endpoint).
+		Exchange(ctx, nil).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON(&resp)).
+		Error(); err != nil {
+		return "", errors.Wrap(err, "get oembed")
+	}
+
+	if resp.Type != "photo" {
+		return "", nil
+	}
+
+	return resp.URL, nil
+}
+
+func (p *openGraph) resolve(ref string) (string, error) {
+	url, err := p.base.Parse(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse url %s", ref)
+	}
+
+	return url.String(), nil
+}
@@ -0,0 +1,137 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+)
+
+func TestOpenGraphMediaURL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		head string
+		url  string
+	}{
+		{
+			name: "video is preferred to image",
+			head: `<meta property="og:image" content="https://example.com/thumb.jpg">
+				<meta property="og:video" content="https://example.com/video.mp4">`,
+			url: "https://example.com/video.mp4",
+		},
+		{
+			name: "secure video url",
+			head: `<meta property="og:video" content="http://example.com/video.mp4">
+				<meta property="og:video:secure_url" content="https://example.com/secure.mp4">`,
+			url: "https://example.com/secure.mp4",
+		},
+		{
+			name: "html player is skipped",
+			head: `<meta property="og:video" content="https://example.com/player">
+				<meta property="og:video:type" content="text/html">
+				<meta property="og:image" content="https://example.com/image.jpg">`,
+			url: "https://example.com/image.jpg",
+		},
+		{
+			name: "twitter player stream",
+			head: `<meta name="twitter:player:stream" content="https://example.com/stream.mp4">`,
+			url:  "https://example.com/stream.mp4",
+		},
+		{
+			name: "relative image url",
+			head: `<meta name="twitter:image" content="/image.png">`,
+			url:  "https://example.com/image.png",
+		},
+		{
+			name: "first repeated tag",
+			head: `<meta property="og:image" content="https://example.com/1.jpg">
+				<meta property="og:image" content="https://example.com/2.jpg">`,
+			url: "https://example.com/1.jpg",
+		},
+		{
+			name: "uppercase property",
+			head: `<meta property="OG:IMAGE" content="https://example.com/image.jpg">`,
+			url:  "https://example.com/image.jpg",
+		},
+		{
+			name: "image_src link",
+			head: `<link rel="image_src" href="image.gif">`,
+			url:  "https://example.com/page/image.gif",
+		},
+		{
+			name: "tags in body are ignored",
+			head: `</head><body><meta property="og:image" content="https://example.com/image.jpg">`,
+		},
+		{
+			name: "no media",
+			head: `<title>Page</title>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, _ := url.Parse("https://example.com/page/")
+			page := &openGraph{base: base, meta: make(map[string]string)}
+			if err := page.parse(strings.NewReader("<html><head>" + tc.head + "</head><body></body></html>")); err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			mediaURL, err := page.mediaURL(context.Background())
+			switch {
+			case tc.url == "" && err == nil:
+				t.Errorf("expected error, got %s", mediaURL)
+			case tc.url != "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case mediaURL != tc.url:
+				t.Errorf("expected %s, got %s", tc.url, mediaURL)
+			}
+		})
+	}
+}
+
+func TestOpenGraphOEmbed(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprintf(w, `<html><head>
+				<link rel="alternate" type="application/json+oembed" href="/oembed">
+				<meta property="og:image" content="/thumb.jpg">
+				</head></html>`)
+		case "/oembed":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"type": "photo", "url": "%s/photo.jpg"}`, server.URL)
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer server.Close()
+
+	ctx := context.Background()
+	source, _ := url.Parse(server.URL + "/page")
+	page, err := getOpenGraph(ctx, source, media.Size(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaURL, err := page.mediaURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mediaURL != server.URL+"/photo.jpg" {
+		t.Errorf("expected oembed photo url, got %s", mediaURL)
+	}
+
+	source, _ = url.Parse(server.URL + "/image.jpg")
+	if page, err := getOpenGraph(ctx, source, media.Size(1<<20)); page != nil || err != nil {
+		t.Errorf("expected nil page for non-html content, got %v, %v", page, err)
+	}
+}
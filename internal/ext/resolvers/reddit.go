@@ -3,23 +3,35 @@ package resolvers
 import (
 	"context"
 	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
 
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/reddit"
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/redditsave"
+	"github.com/jfk9w/hikkabot/v4/internal/core"
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
+	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
-	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
 	"github.com/pkg/errors"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
 type RedditContext interface {
 	reddit.Context
 	redditsave.Context
+	core.BlobContext
 }
 
 type Reddit[C RedditContext] struct {
-	client     httpf.Client
+	clock      syncf.Clock
+	client     reddit.Client[C]
+	blobs      *core.Blobs[C]
+	maxSize    media.Size
+	ffmpeg     bool
 	redditsave redditsave.Interface
 }
 
@@ -33,16 +45,35 @@ func (r *Reddit[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return err
 	}
 
-	var redditsave redditsave.Client[C]
-	if err := app.Use(ctx, &redditsave, false); err != nil {
+	var blobs core.Blobs[C]
+	if err := app.Use(ctx, &blobs, false); err != nil {
 		return err
 	}
 
+	if app.Config().RedditsaveConfig().Enabled {
+		var redditsave redditsave.Client[C]
+		if err := app.Use(ctx, &redditsave, false); err != nil {
+			return err
+		}
+
+		r.redditsave = redditsave
+	}
+
+	_, err := exec.LookPath("ffmpeg")
+	logf.Get(r).Resultf(ctx, logf.Info, logf.Warn, "check ffmpeg for muxing v.redd.it audio: %v", err)
+
+	r.clock = app
 	r.client = client
-	r.redditsave = redditsave
+	r.blobs = &blobs
+	r.maxSize = app.Config().BlobConfig().MaxSize
+	r.ffmpeg = err == nil
 	return nil
 }
 
+func (r *Reddit[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Hosts: []string{"preview.redd.it", reddit.VideoHost}}
+}
+
 func (r *Reddit[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
 	switch source.Host {
 	case "preview.redd.it":
@@ -52,7 +83,13 @@ func (r *Reddit[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef
 			Buffer: true,
 		}, nil
 
-	case "v.redd.it":
+	case reddit.VideoHost:
+		metaRef, err := r.resolveDASH(ctx, source)
+		if err == nil || r.redditsave == nil {
+			return metaRef, err
+		}
+
+		logf.Get(r).Warnf(ctx, "resolve [%s] via dash playlist: %v", source, err)
 		url, err := r.redditsave.ResolveURL(ctx, source.String())
 		if err != nil {
 			return nil, errors.Wrap(err, "via redditsave")
@@ -71,3 +108,139 @@ func (r *Reddit[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef
 		return nil, nil
 	}
 }
+
+// resolveDASH selects the best video and audio streams from DASH playlist and muxes them with ffmpeg.
+// Videos without audio are downloaded directly.
+func (r *Reddit[C]) resolveDASH(ctx context.Context, source *url.URL) (media.MetaRef, error) {
+	playlistURL, err := reddit.DASHPlaylistURL(source)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := r.client.GetDASHPlaylist(ctx, playlistURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "get dash playlist")
+	}
+
+	videoURL, err := playlist.Best("video")
+	if err != nil {
+		return nil, err
+	}
+
+	if videoURL == "" {
+		return nil, errors.New("no video in dash playlist")
+	}
+
+	audioURL, err := playlist.Best("audio")
+	if err != nil {
+		return nil, err
+	}
+
+	if audioURL == "" {
+		return &media.HTTPRef{
+			URL:    videoURL,
+			Client: r.client,
+			Buffer: true,
+			Meta: &media.Meta{
+				MIMEType: "video/mp4",
+			},
+		}, nil
+	}
+
+	if !r.ffmpeg {
+		return nil, errors.New("ffmpeg is required to mux audio")
+	}
+
+	return r.mux(ctx, videoURL, audioURL)
+}
+
+// mux downloads video and audio streams and muxes them with ffmpeg.
+// Streams are downloaded with reddit client beforehand, so that ffmpeg does not access the network,
+// and the output is limited to the maximum blob size.
+func (r *Reddit[C]) mux(ctx context.Context, videoURL, audioURL string) (media.MetaRef, error) {
+	dir, err := r.blobs.TempDir("vreddit-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary directory")
+	}
+
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logf.Get(r).Warnf(ctx, "remove temporary directory [%s]: %v", dir, err)
+		}
+	}()
+
+	videoPath, audioPath := filepath.Join(dir, "video.mp4"), filepath.Join(dir, "audio.mp4")
+	if err := r.downloadStreams(ctx, map[string]string{videoPath: videoURL, audioPath: audioURL}); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "output.mp4")
+	inputArgs := ffmpeg.KwArgs{"protocol_whitelist": "file"}
+	streams := []*ffmpeg.Stream{ffmpeg.Input(videoPath, inputArgs).Video(), ffmpeg.Input(audioPath, inputArgs).Audio()}
+	stream := ffmpeg.OutputContext(ctx, streams, path, ffmpeg.KwArgs{
+		"c":        "copy",
+		"movflags": "+faststart",
+		// truncated output exceeds blob size bounds, so it is rejected by blob storage
+		"fs": int64(r.maxSize),
+	})
+
+	startTime := r.clock.Now()
+	err = stream.OverWriteOutput().Run()
+	logf.Get(r).Resultf(ctx, logf.Debug, logf.Warn,
+		"mux [%s] + [%s] in %s: %v",
+		videoURL, audioURL, r.clock.Now().Sub(startTime), err)
+	if err != nil {
+		return nil, errors.Wrap(err, "mux")
+	}
+
+	// temporary file is moved to blob storage so that its lifecycle is managed by it
+	blob := r.blobs.Buffer("video/mp4", syncf.Val[flu.Input]{V: media.TempFile(path)})
+	meta, err := blob.GetMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := blob.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media.LocalRef{
+		Input: input,
+		Meta:  meta,
+	}, nil
+}
+
+// downloadStreams downloads DASH streams into local files by path.
+// Stream sizes are checked beforehand so that their total size does not exceed the maximum blob size.
+func (r *Reddit[C]) downloadStreams(ctx context.Context, streams map[string]string) error {
+	var total media.Size
+	refs := make(map[string]media.HTTPRef, len(streams))
+	for path, url := range streams {
+		ref := media.HTTPRef{URL: url, Client: r.client}
+		meta, err := ref.GetMeta(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "get %s meta", url)
+		}
+
+		if meta.Size <= 0 {
+			return errors.Errorf("unknown %s size", url)
+		}
+
+		total += meta.Size
+		ref.Meta = meta
+		refs[path] = ref
+	}
+
+	if total >= r.maxSize {
+		return errors.Wrapf(core.ErrTooLarge, "streams size %s", total)
+	}
+
+	for path, ref := range refs {
+		if _, err := ref.Download(ctx, flu.File(path), media.DownloadOptions{}); err != nil {
+			return errors.Wrapf(err, "download %s", ref.URL)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package resolvers
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/redgifs"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/pkg/errors"
+)
+
+type Redgifs[C redgifs.Context] struct {
+	client  *redgifs.Client[C]
+	quality string
+}
+
+func (r Redgifs[C]) String() string {
+	return "media-resolver.redgifs"
+}
+
+func (r *Redgifs[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	var client redgifs.Client[C]
+	if err := app.Use(ctx, &client, false); err != nil {
+		return err
+	}
+
+	r.client = &client
+	r.quality = app.Config().RedgifsConfig().Quality
+	return nil
+}
+
+func (r *Redgifs[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Hosts: []string{"redgifs.com"}}
+}
+
+func (r *Redgifs[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
+	if isMediaPath(source.Path) {
+		// direct media links still require proper headers
+		return &media.HTTPRef{
+			URL:    source.String(),
+			Client: r.client,
+			Buffer: true,
+		}, nil
+	}
+
+	id := path.Base(strings.TrimRight(source.Path, "/"))
+	if id == "" || id == "." || id == "/" {
+		return nil, errors.Errorf("no gif id in %s", source)
+	}
+
+	gif, err := r.client.GetGif(ctx, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get gif %s", id)
+	}
+
+	mediaURL := gif.URLs.HD
+	if r.quality == redgifs.SD && gif.URLs.SD != "" || mediaURL == "" {
+		mediaURL = gif.URLs.SD
+	}
+
+	if mediaURL == "" {
+		return nil, errors.Errorf("no media urls for gif %s", id)
+	}
+
+	return &media.HTTPRef{
+		URL:    mediaURL,
+		Client: r.client,
+		Buffer: true,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package resolvers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/redgifs"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+func TestRedgifsEgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+	}))
+	defer server.Close()
+
+	source, err := url.Parse(server.URL + "/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		egress *media.EgressPolicy
+		denied bool
+	}{
+		{
+			name:   "private address",
+			egress: &media.EgressPolicy{},
+			denied: true,
+		},
+		{
+			name:   "private address allowed",
+			egress: &media.EgressPolicy{AllowPrivate: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var client redgifs.Client[redgifs.Context]
+			if err := client.Standalone(context.Background(), syncf.DefaultClock, redgifs.Config{}, tc.egress); err != nil {
+				t.Fatal(err)
+			}
+
+			r := &Redgifs[redgifs.Context]{client: &client}
+			ref, err := r.Resolve(context.Background(), source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ref.GetMeta(context.Background())
+			switch {
+			case tc.denied && !errors.Is(err, media.ErrEgressDenied):
+				t.Errorf("expected denial, got %v", err)
+			case !tc.denied && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package resolvers
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w/hikkabot/v4/internal/core"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+type YtDlpConfig struct {
+	Enabled bool     `yaml:"enabled,omitempty" doc:"Whether yt-dlp-based media resolver should be enabled. Requires yt-dlp (or a compatible binary) to be present." default:"true"`
+	Path    string   `yaml:"path,omitempty" doc:"Path to yt-dlp-compatible binary. Looked up in $PATH if not absolute." default:"yt-dlp"`
+	Hosts   []string `yaml:"hosts,omitempty" doc:"Hosts to resolve media with yt-dlp (subdomains are matched as well)." default:"[\"youtube.com\",\"youtu.be\",\"streamable.com\",\"coub.com\",\"vimeo.com\",\"tiktok.com\"]"`
+	Format  string   `yaml:"format,omitempty" doc:"yt-dlp format selector." default:"bv*[ext=mp4][vcodec^=avc1]+ba[ext=m4a]/b[ext=mp4]/bv*+ba/b"`
+}
+
+type YtDlpContext interface {
+	core.BlobContext
+	core.EgressContext
+	YtDlpConfig() YtDlpConfig
+}
+
+// YtDlp resolves media by downloading it with an external yt-dlp-compatible binary.
+// yt-dlp fetches URLs by itself, so it is run behind a local proxy which enforces egress policy
+// for all of its requests (including redirects and requests to CDNs).
+type YtDlp[C YtDlpContext] struct {
+	clock   syncf.Clock
+	blobs   *core.Blobs[C]
+	egress  *media.EgressPolicy
+	path    string
+	config  YtDlpConfig
+	maxSize media.Size
+}
+
+func (r YtDlp[C]) String() string {
+	return "media-resolver.ytdlp"
+}
+
+func (r *YtDlp[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	config := app.Config().YtDlpConfig()
+	if !config.Enabled || len(config.Hosts) == 0 {
+		return apfel.ErrDisabled
+	}
+
+	path, err := exec.LookPath(config.Path)
+	logf.Get(r).Resultf(ctx, logf.Info, logf.Warn, "check %s: %v", config.Path, err)
+	if err != nil {
+		return apfel.ErrDisabled
+	}
+
+	var blobs core.Blobs[C]
+	if err := app.Use(ctx, &blobs, false); err != nil {
+		return err
+	}
+
+	var egress core.Egress[C]
+	if err := app.Use(ctx, &egress, false); err != nil {
+		return err
+	}
+
+	r.clock = app
+	r.blobs = &blobs
+	r.egress = egress.Policy()
+	r.path = path
+	r.config = config
+	r.maxSize = app.Config().BlobConfig().MaxSize
+	return nil
+}
+
+func (r *YtDlp[C]) ResolverRule() media.ResolverRule {
+	return media.ResolverRule{Hosts: r.config.Hosts}
+}
+
+func (r *YtDlp[C]) Resolve(ctx context.Context, source *url.URL) (media.MetaRef, error) {
+	if err := r.checkSource(ctx, source); err != nil {
+		return nil, err
+	}
+
+	dir, err := r.blobs.TempDir("ytdlp-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary directory")
+	}
+
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logf.Get(r).Warnf(ctx, "remove temporary directory [%s]: %v", dir, err)
+		}
+	}()
+
+	path, err := r.download(ctx, source, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		return nil, errors.Errorf("unknown file type: %s", filepath.Base(path))
+	}
+
+	// temporary file is moved to blob storage so that its lifecycle is managed by it
+	mimeType, _, _ = mime.ParseMediaType(mimeType)
+	blob := r.blobs.Buffer(mimeType, syncf.Val[flu.Input]{V: media.TempFile(path)})
+	meta, err := blob.GetMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := blob.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media.LocalRef{
+		Input: input,
+		Meta:  meta,
+	}, nil
+}
+
+// checkSource checks the source URL against egress policy beforehand, so that yt-dlp is not run for denied sources.
+func (r *YtDlp[C]) checkSource(ctx context.Context, source *url.URL) error {
+	switch source.Scheme {
+	case "http", "https":
+	default:
+		return errors.Wrapf(media.ErrEgressDenied, "scheme %s", source.Scheme)
+	}
+
+	if r.egress == nil {
+		return nil
+	}
+
+	return r.egress.CheckHost(ctx, source.Hostname())
+}
+
+func (r *YtDlp[C]) download(ctx context.Context, source *url.URL, dir string) (string, error) {
+	args := []string{
+		"--no-playlist",
+		"--no-progress",
+		"--no-simulate",
+		"--format", r.config.Format,
+		"--merge-output-format", "mp4",
+		"--max-filesize", strconv.FormatInt(int64(r.maxSize), 10),
+		"--paths", dir,
+		"--output", "%(id)s.%(ext)s",
+		"--print", "after_move:filepath",
+	}
+
+	if r.egress != nil {
+		proxy, err := media.NewEgressProxy(r.egress)
+		if err != nil {
+			return "", errors.Wrap(err, "start egress proxy")
+		}
+
+		defer flu.CloseQuietly(proxy)
+		// external downloaders (like ffmpeg) may not use the proxy, so only native ones are allowed
+		args = append(args, "--proxy", proxy.URL(), "--downloader", "native")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.path, append(args, source.String())...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	startTime := r.clock.Now()
+	err := cmd.Run()
+	logf.Get(r).Resultf(ctx, logf.Debug, logf.Warn, "download [%s] in %s: %v", source, r.clock.Now().Sub(startTime), err)
+	if err != nil {
+		return "", errors.Wrapf(err, "run: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	path := strings.TrimSpace(stdout.String())
+	if idx := strings.LastIndexByte(path, '\n'); idx >= 0 {
+		path = path[idx+1:]
+	}
+
+	if path == "" {
+		// yt-dlp skips files exceeding --max-filesize without an error
+		return "", errors.Errorf("no file downloaded (size limit is %s): %s", r.maxSize, strings.TrimSpace(stderr.String()))
+	}
+
+	return path, nil
+}
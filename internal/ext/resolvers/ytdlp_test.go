@@ -0,0 +1,131 @@
+package resolvers
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+// newTestYtDlp creates a YtDlp resolver with a shell script in place of yt-dlp binary.
+func newTestYtDlp(t *testing.T, script string) *YtDlp[YtDlpContext] {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "yt-dlp")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return &YtDlp[YtDlpContext]{
+		clock:   syncf.DefaultClock,
+		path:    path,
+		maxSize: 1 << 20,
+	}
+}
+
+func TestYtDlpDownload(t *testing.T) {
+	source, _ := url.Parse("https://example.com/video")
+	for _, tc := range []struct {
+		name   string
+		script string
+		path   string
+		err    string
+	}{
+		{
+			name:   "last printed path",
+			script: `echo "$PWD/a.mp4"; echo "/tmp/dir/b.mp4"`,
+			path:   "/tmp/dir/b.mp4",
+		},
+		{
+			name:   "no output",
+			script: `echo "File is larger than max-filesize" >&2`,
+			err:    "no file downloaded (size limit is 1M): File is larger than max-filesize",
+		},
+		{
+			name:   "failure",
+			script: `echo "Unsupported URL" >&2; exit 1`,
+			err:    "run: Unsupported URL",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestYtDlp(t, tc.script)
+			path, err := r.download(context.Background(), source, t.TempDir())
+			switch {
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case path != tc.path:
+				t.Errorf("expected path %q, got %q", tc.path, path)
+			}
+		})
+	}
+}
+
+func TestYtDlpCheckSource(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+		egress *media.EgressPolicy
+		denied bool
+	}{
+		{
+			name:   "private address",
+			source: "http://127.0.0.1/video",
+			egress: &media.EgressPolicy{},
+			denied: true,
+		},
+		{
+			name:   "private address allowed",
+			source: "http://127.0.0.1/video",
+			egress: &media.EgressPolicy{AllowPrivate: true},
+		},
+		{
+			name:   "unsupported scheme",
+			source: "file:///etc/passwd",
+			egress: &media.EgressPolicy{AllowPrivate: true},
+			denied: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := url.Parse(tc.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := newTestYtDlp(t, "exit 1")
+			r.egress = tc.egress
+			err = r.checkSource(context.Background(), source)
+			switch {
+			case tc.denied && !errors.Is(err, media.ErrEgressDenied):
+				t.Errorf("expected denial, got %v", err)
+			case !tc.denied && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestYtDlpDownloadProxy(t *testing.T) {
+	source, _ := url.Parse("https://example.com/video")
+	// the script fails unless it is run behind the egress proxy with native downloaders
+	r := newTestYtDlp(t, `case "$*" in
+*"--proxy http://127.0.0.1:"*"--downloader native"*) echo "/tmp/dir/video.mp4" ;;
+*) echo "no proxy: $*" >&2; exit 1 ;;
+esac`)
+
+	r.egress = &media.EgressPolicy{}
+	path, err := r.download(context.Background(), source, t.TempDir())
+	switch {
+	case err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case path != "/tmp/dir/video.mp4":
+		t.Errorf("expected path /tmp/dir/video.mp4, got %q", path)
+	}
+}
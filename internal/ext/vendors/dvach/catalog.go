@@ -30,6 +30,10 @@ type CatalogData struct {
 	Query  util.Regexp `json:"query,omitempty"`
 	Offset int         `json:"offset,omitempty"`
 	Auto   []string    `json:"auto,omitempty"`
+
+	Spoiler  feed.Spoiler `json:"spoiler,omitempty"`
+	NSFW     bool         `json:"nsfw,omitempty"`
+	Document bool         `json:"document,omitempty"`
 }
 
 type Catalog[C Context] struct {
@@ -70,6 +74,10 @@ loop:
 		case option == "auto":
 			data.Auto = options[i+1:]
 			break loop
+		case option == "s" || option == "!s":
+			data.Spoiler, _ = feed.ParseSpoiler(option)
+		case option == "doc":
+			data.Document = true
 		case strings.HasPrefix(option, "re="):
 			option = option[3:]
 			fallthrough
@@ -87,6 +95,12 @@ loop:
 		return nil, errors.Wrap(err, "get catalog")
 	}
 
+	if board, err := v.client.GetBoard(ctx, data.Board); err != nil {
+		logf.Get(v).Warnf(ctx, "failed to get board [%s]: %v", data.Board, err)
+	} else {
+		data.NSFW = board.IsNSFW()
+	}
+
 	draft := &feed.Draft{
 		SubID: data.Board + "/" + data.Query.String(),
 		Name:  catalog.BoardName + " /" + data.Query.String() + "/",
@@ -142,7 +156,13 @@ func (v *Catalog[C]) writeHTML(ctx context.Context, data CatalogData, post *dvac
 
 	var mediaRef receiver.MediaRef
 	if len(post.Files) > 0 {
-		mediaRef = v.mediator.Mediate(ctx, post.Files[0].URL(), nil)
+		options := feed.MediaOptions{
+			Spoiler:  data.Spoiler.Apply(data.NSFW),
+			Document: data.Document,
+			Video:    internal.VideoMeta(post.Files[0]),
+		}
+
+		mediaRef = v.mediator.Mediate(ctx, post.Files[0].URL(), options)
 	}
 
 	return func(html *tghtml.Writer) error {
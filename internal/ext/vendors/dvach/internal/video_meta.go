@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/dvach"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+)
+
+// VideoMeta returns video attributes provided by the board API.
+// It returns nil if the file is not a video or no attributes are provided.
+func VideoMeta(file dvach.File) *media.VideoMeta {
+	if !strings.HasPrefix(file.Type.MIMEType(), "video/") {
+		return nil
+	}
+
+	video := new(media.VideoMeta)
+	if file.DurationSecs != nil {
+		video.Duration = time.Duration(*file.DurationSecs) * time.Second
+	}
+
+	if file.Width != nil {
+		video.Width = *file.Width
+	}
+
+	if file.Height != nil {
+		video.Height = *file.Height
+	}
+
+	if *video == (media.VideoMeta{}) {
+		return nil
+	}
+
+	return video
+}
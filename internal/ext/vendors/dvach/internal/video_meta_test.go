@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/dvach"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+)
+
+func TestVideoMeta(t *testing.T) {
+	value := func(v int) *int { return &v }
+	for _, tc := range []struct {
+		name  string
+		file  dvach.File
+		video *media.VideoMeta
+	}{
+		{
+			name:  "webm",
+			file:  dvach.File{Type: dvach.WebM, DurationSecs: value(15), Width: value(1280), Height: value(720)},
+			video: &media.VideoMeta{Duration: 15 * time.Second, Width: 1280, Height: 720},
+		},
+		{
+			name:  "mp4 without duration",
+			file:  dvach.File{Type: dvach.MP4, Width: value(640), Height: value(480)},
+			video: &media.VideoMeta{Width: 640, Height: 480},
+		},
+		{
+			name: "mp4 without metadata",
+			file: dvach.File{Type: dvach.MP4},
+		},
+		{
+			name: "image",
+			file: dvach.File{Type: dvach.JPEG, Width: value(640), Height: value(480)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			video := VideoMeta(tc.file)
+			if !reflect.DeepEqual(video, tc.video) {
+				t.Errorf("expected %+v, got %+v", tc.video, video)
+			}
+		})
+	}
+}
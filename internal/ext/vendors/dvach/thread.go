@@ -30,6 +30,11 @@ type ThreadData struct {
 	MediaOnly bool   `json:"media_only,omitempty"`
 	Offset    int    `json:"offset,omitempty"`
 	Tag       string `json:"tag"`
+
+	Spoiler    feed.Spoiler `json:"spoiler,omitempty"`
+	NSFW       bool         `json:"nsfw,omitempty"`
+	DedupScope string       `json:"dedup_scope,omitempty"`
+	Document   bool         `json:"document,omitempty"`
 }
 
 type Thread[C Context] struct {
@@ -69,11 +74,25 @@ func (v *Thread[C]) Parse(ctx context.Context, ref string, options []string) (*f
 		switch {
 		case option == "m":
 			data.MediaOnly = true
+		case option == "doc":
+			data.Document = true
 		case strings.HasPrefix(option, "#"):
 			data.Tag = option
+		default:
+			if spoiler, ok := feed.ParseSpoiler(option); ok {
+				data.Spoiler = spoiler
+			} else if scope, ok := feed.ParseDedupScope(option); ok {
+				data.DedupScope = scope
+			}
 		}
 	}
 
+	if board, err := v.client.GetBoard(ctx, data.Board); err != nil {
+		logf.Get(v).Warnf(ctx, "failed to get board [%s]: %v", data.Board, err)
+	} else {
+		data.NSFW = board.IsNSFW()
+	}
+
 	post, err := v.client.GetPost(ctx, data.Board, data.Num)
 	if err != nil {
 		return nil, errors.Wrap(err, "get post")
@@ -128,14 +147,20 @@ func (v *Thread[C]) writeHTML(ctx context.Context, header feed.Header, data Thre
 		return nil
 	}
 
-	var dedupKey *feed.ID
+	options := feed.MediaOptions{
+		DedupScope: data.DedupScope,
+		Spoiler:    data.Spoiler.Apply(data.NSFW),
+		Document:   data.Document,
+	}
+
 	if data.MediaOnly {
-		dedupKey = &header.FeedID
+		options.DedupKey = &header.FeedID
 	}
 
 	mediaRefs := make([]receiver.MediaRef, len(post.Files))
 	for i, file := range post.Files {
-		mediaRefs[i] = v.mediator.Mediate(ctx, file.URL(), dedupKey)
+		options.Video = internal.VideoMeta(file)
+		mediaRefs[i] = v.mediator.Mediate(ctx, file.URL(), options)
 	}
 
 	return func(html *html.Writer) error {
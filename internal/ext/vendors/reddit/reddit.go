@@ -6,11 +6,10 @@ import (
 
 	"github.com/jfk9w/hikkabot/v4/internal/3rdparty/reddit"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
-
-	"github.com/jfk9w-go/flu/colf"
 )
 
 type Score struct {
+	SentThings     int
 	First          *time.Time
 	LikedThings    int
 	DislikedThings int
@@ -19,9 +18,8 @@ type Score struct {
 }
 
 type StorageTx interface {
-	Score(feedID feed.ID, thingIDs []string) (*Score, error)
+	Score(header feed.Header) (*Score, error)
 	GetPercentile(subreddit string, top float64) (int, error)
-	GetFreshThingIDs(ids colf.Set[string]) (colf.Set[string], error)
 	DeleteStaleThings(until time.Time) (int64, error)
 }
 
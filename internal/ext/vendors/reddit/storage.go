@@ -9,8 +9,6 @@ import (
 	"github.com/jfk9w/hikkabot/v4/internal/core"
 	"github.com/jfk9w/hikkabot/v4/internal/feed"
 
-	"github.com/jfk9w-go/flu/colf"
-
 	"github.com/jfk9w-go/flu/logf"
 
 	"github.com/pkg/errors"
@@ -108,7 +106,7 @@ func (stx *sqlStorageTx) GetPercentile(subreddit string, top float64) (int, erro
 		Error
 }
 
-func (stx *sqlStorageTx) Score(feedID feed.ID, thingIDs []string) (*Score, error) {
+func (stx *sqlStorageTx) Score(header feed.Header) (*Score, error) {
 	if !stx.isPG {
 		logf.Get(storageServiceID).Warnf(context.TODO(), "Score not supported, you may want to switch to postgres")
 		return nil, feed.ErrUnsupported
@@ -116,14 +114,19 @@ func (stx *sqlStorageTx) Score(feedID feed.ID, thingIDs []string) (*Score, error
 
 	score := new(Score)
 	return score, stx.db.Raw( /* language=SQL */ `
-		select min(time) as first,
+		with sent as (
+		  select item_id from sent_item
+		  where feed_id = ? and vendor = ? and sub_id = ?
+		)
+		select (select count(1) from sent) as sent_things,
+		       min(time) as first,
 		       count(distinct case when type in ('click', 'like') then jsonb_extract_path_text(data, 'thing_id') end) as liked_things,
 		       count(distinct case when type = 'dislike' then jsonb_extract_path(data, 'user_id') end) as disliked_things,
 		       count(distinct case when type in ('click', 'like') then jsonb_extract_path(data, 'user_id') end) as likes,
 		       count(distinct case when type = 'dislike' then jsonb_extract_path(data, 'user_id') end) as dislikes
 		from event
-		where chat_id = ? and jsonb_extract_path_text(data, 'thing_id') in ?`,
-		feedID, thingIDs).
+		where chat_id = ? and jsonb_extract_path_text(data, 'thing_id') in (select item_id from sent)`,
+		header.FeedID, header.Vendor, header.SubID, header.FeedID).
 		Scan(score).
 		Error
 }
@@ -134,20 +137,3 @@ func (stx *sqlStorageTx) DeleteStaleThings(until time.Time) (int64, error) {
 		Delete(new(reddit.Thing))
 	return tx.RowsAffected, tx.Error
 }
-
-func (stx *sqlStorageTx) GetFreshThingIDs(ids colf.Set[string]) (colf.Set[string], error) {
-	freshIDs := make(colf.Slice[string], 0)
-	if err := stx.db.Raw( /* language=SQL */ `
-		select id from reddit
-		where id in ?
-		order by num_id`,
-		colf.ToSlice[string](ids)).
-		Scan(&freshIDs).
-		Error; err != nil {
-		return nil, err
-	}
-
-	set := make(colf.Set[string], len(freshIDs))
-	colf.AddAll[string](&set, freshIDs)
-	return set, nil
-}
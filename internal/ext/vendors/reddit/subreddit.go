@@ -20,6 +20,7 @@ import (
 	"github.com/jfk9w-go/flu/me3x"
 	"github.com/jfk9w-go/flu/syncf"
 	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/html"
 	"github.com/jfk9w-go/telegram-bot-api/ext/tapp"
 	"github.com/pkg/errors"
 )
@@ -50,20 +51,22 @@ type SubredditContext interface {
 }
 
 type SubredditData struct {
-	Subreddit     string           `json:"subreddit"`
+	Subreddit string `json:"subreddit"`
+	// SentIDs is kept only for migrating legacy subscription data to feed.SentItems.
 	SentIDs       colf.Set[string] `json:"sent_ids,omitempty"`
 	LastCleanSecs int64            `json:"last_clean,omitempty"`
 	Layout        ThingLayout      `json:"layout,omitempty"`
 }
 
 type Subreddit[C SubredditContext] struct {
-	config   SubredditConfig
-	clock    syncf.Clock
-	storage  StorageInterface
-	client   reddit.Interface
-	telegram telegram.Client
-	writer   thingWriter[C]
-	metrics  me3x.Registry
+	config    SubredditConfig
+	clock     syncf.Clock
+	storage   StorageInterface
+	sentItems *feed.SentItems
+	client    reddit.Interface
+	telegram  telegram.Client
+	writer    thingWriter[C]
+	metrics   me3x.Registry
 }
 
 func (v *Subreddit[C]) String() string {
@@ -109,6 +112,13 @@ func (v *Subreddit[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error
 	v.config = app.Config().SubredditConfig()
 	v.clock = app
 	v.storage = storage
+	v.sentItems = &feed.SentItems{
+		Storage: storage,
+		Clock:   app,
+		Vendor:  v.String(),
+		TTL:     v.config.ThingTTL.Value,
+	}
+
 	v.client = client
 	v.telegram = bot.Bot()
 	v.writer = writer
@@ -153,6 +163,14 @@ func (v *Subreddit[C]) Parse(ctx context.Context, ref string, options []string)
 			data.Layout.HideTitle = true
 		case "l":
 			data.Layout.ShowPreference = true
+		case "doc":
+			data.Layout.Document = true
+		default:
+			if spoiler, ok := feed.ParseSpoiler(option); ok {
+				data.Layout.Spoiler = spoiler
+			} else if scope, ok := feed.ParseDedupScope(option); ok {
+				data.Layout.DedupScope = scope
+			}
 		}
 	}
 
@@ -187,6 +205,24 @@ func (v *Subreddit[C]) Refresh(ctx context.Context, header feed.Header, refresh
 		return errors.Wrap(err, "save things")
 	}
 
+	if len(data.SentIDs) > 0 {
+		if err := v.sentItems.Add(ctx, header, colf.ToSlice[string](data.SentIDs)...); err != nil {
+			return errors.Wrap(err, "migrate sent ids")
+		}
+
+		data.SentIDs = nil
+	}
+
+	thingIDs := make([]string, len(things))
+	for i, thing := range things {
+		thingIDs[i] = thing.Data.ID
+	}
+
+	sentIDs, err := v.sentItems.Sent(ctx, header, thingIDs)
+	if err != nil {
+		return errors.Wrap(err, "get sent ids")
+	}
+
 	var (
 		count      = 0
 		cleanData  = syncf.Lazy[any](func(ctx context.Context) (any, error) { return nil, v.cleanData(ctx, &data) })
@@ -195,7 +231,7 @@ func (v *Subreddit[C]) Refresh(ctx context.Context, header feed.Header, refresh
 
 	for _, thing := range things {
 		thing := thing.Data
-		if data.SentIDs[thing.ID] {
+		if sentIDs[thing.ID] {
 			continue
 		}
 
@@ -217,8 +253,7 @@ func (v *Subreddit[C]) Refresh(ctx context.Context, header feed.Header, refresh
 			return err
 		}
 
-		data.SentIDs.Add(thing.ID)
-		if err := refresh.Submit(ctx, writeHTML, data); err != nil {
+		if err := refresh.Submit(ctx, v.markSent(header, thing.ID, writeHTML), data); err != nil {
 			return err
 		}
 
@@ -231,6 +266,22 @@ func (v *Subreddit[C]) Refresh(ctx context.Context, header feed.Header, refresh
 	return nil
 }
 
+// markSent wraps writeHTML so that the thing is saved as sent only after it has been delivered.
+// Failed deliveries are retried on the next refresh.
+func (v *Subreddit[C]) markSent(header feed.Header, thingID string, writeHTML feed.WriteHTML) feed.WriteHTML {
+	return func(html *html.Writer) error {
+		if err := writeHTML(html); err != nil {
+			return err
+		}
+
+		if err := html.Flush(); err != nil {
+			return err
+		}
+
+		return errors.Wrap(v.sentItems.Add(html.Context(), header, thingID), "save sent id")
+	}
+}
+
 func (v *Subreddit[C]) cleanData(ctx context.Context, data *SubredditData) error {
 	now := v.clock.Now()
 	if now.Sub(time.Unix(data.LastCleanSecs, 0)) < v.config.CleanInterval.Value {
@@ -247,12 +298,15 @@ func (v *Subreddit[C]) cleanData(ctx context.Context, data *SubredditData) error
 			logf.Get(v).Infof(ctx, "deleted %d stale things", deletedThings)
 		}
 
-		freshIDs, err := tx.GetFreshThingIDs(data.SentIDs)
+		deletedItems, err := v.sentItems.Clean(ctx)
 		if err != nil {
 			return err
 		}
 
-		data.SentIDs = freshIDs
+		if deletedItems > 0 {
+			logf.Get(v).Infof(ctx, "deleted %d stale sent items", deletedItems)
+		}
+
 		data.LastCleanSecs = now.Unix()
 
 		return nil
@@ -271,11 +325,11 @@ func (v *Subreddit[C]) getPercentile(ctx context.Context, header feed.Header, da
 	var percentile int
 	return percentile, v.storage.RedditTx(ctx, func(tx StorageTx) error {
 		boost := 0.
-		if (data.Layout.ShowPreference || data.Layout.ShowPaywall) && len(data.SentIDs) > 0 {
-			score, err := tx.Score(header.FeedID, colf.ToSlice[string](data.SentIDs))
+		if data.Layout.ShowPreference || data.Layout.ShowPaywall {
+			score, err := tx.Score(header)
 			switch {
-			case err == nil && score.First != nil && v.clock.Now().Sub(*score.First) >= pacing.Gain.Value:
-				thingRatio := (float64(score.LikedThings) - float64(score.DislikedThings)) / float64(len(data.SentIDs))
+			case err == nil && score.SentThings > 0 && score.First != nil && v.clock.Now().Sub(*score.First) >= pacing.Gain.Value:
+				thingRatio := (float64(score.LikedThings) - float64(score.DislikedThings)) / float64(score.SentThings)
 				if members < pacing.Members {
 					members = pacing.Members
 				}
@@ -23,6 +23,10 @@ type ThingLayout struct {
 	HideMediaLink  bool `json:"hide_media_link,omitempty"`
 	ShowPaywall    bool `json:"show_paywall,omitempty"`
 	ShowPreference bool `json:"show_preference,omitempty"`
+
+	Spoiler    feed.Spoiler `json:"spoiler,omitempty"`
+	DedupScope string       `json:"dedup_scope,omitempty"`
+	Document   bool         `json:"document,omitempty"`
 }
 
 func (l *ThingLayout) WriteHTML(feedID feed.ID, thing reddit.ThingData, mediaRef receiver.MediaRef) feed.WriteHTML {
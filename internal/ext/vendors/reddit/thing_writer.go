@@ -34,18 +34,23 @@ func (w *thingWriter[C]) Include(ctx context.Context, app apfel.MixinApp[C]) err
 func (w *thingWriter[C]) writeHTML(ctx context.Context, feedID feed.ID, layout ThingLayout, thing reddit.ThingData) feed.WriteHTML {
 	var mediaRef receiver.MediaRef
 	if !thing.IsSelf && !layout.HideMedia {
-		var dedupKey *feed.ID
+		options := feed.MediaOptions{
+			DedupScope: layout.DedupScope,
+			Spoiler:    layout.Spoiler.Apply(thing.Over18 || thing.Spoiler),
+			Document:   layout.Document,
+		}
+
 		if !layout.ShowText {
-			dedupKey = &feedID
+			options.DedupKey = &feedID
 		}
 
-		mediaRef = w.mediaRef(ctx, thing, dedupKey)
+		mediaRef = w.mediaRef(ctx, thing, options)
 	}
 
 	return layout.WriteHTML(feedID, thing, mediaRef)
 }
 
-func (w *thingWriter[C]) mediaRef(ctx context.Context, thing reddit.ThingData, dedupKey *feed.ID) receiver.MediaRef {
+func (w *thingWriter[C]) mediaRef(ctx context.Context, thing reddit.ThingData, options feed.MediaOptions) receiver.MediaRef {
 	url := thing.URL.String
 	if thing.Domain == "v.redd.it" {
 		url = thing.MediaContainer.FallbackURL()
@@ -63,5 +68,5 @@ func (w *thingWriter[C]) mediaRef(ctx context.Context, thing reddit.ThingData, d
 		}
 	}
 
-	return w.mediator.Mediate(ctx, url, dedupKey)
+	return w.mediator.MediateAll(ctx, url, options)
 }
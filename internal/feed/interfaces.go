@@ -6,6 +6,8 @@ import (
 
 	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
 
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/colf"
 	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
 )
 
@@ -64,6 +66,9 @@ type Poller interface {
 // Blobs provides means for temporary large memory allocation for media downloads.
 type Blobs interface {
 	Buffer(mimeType string, ref media.Ref) media.MetaRef
+	// Acquire protects the blob from eviction until `release` is called.
+	// It is a no-op for inputs which are not managed by Blobs.
+	Acquire(ctx context.Context, input flu.Input) (release func())
 }
 
 // EventTx represents a database transaction on "event data subset".
@@ -92,7 +97,41 @@ type EventStorage interface {
 // MediaHashStorage keeps track of duplicate media.
 type MediaHashStorage interface {
 	// IsMediaUnique returns `true` if passed `hash` is not present in storage yet.
-	IsMediaUnique(ctx context.Context, hash *MediaHash) (bool, error)
+	// Perceptual hashes are also compared with stored hashes of the same type
+	// and considered duplicate if Hamming distance between them does not exceed `maxDistance`.
+	// If the hash is a duplicate, MatchedURL will be set to the URL of the matched media.
+	// Hashes of all feeds in hash Scope are compared as well.
+	IsMediaUnique(ctx context.Context, hash *MediaHash, maxDistance int) (bool, error)
+	// GetMediaHashes returns hashes stored for media with `url` along with hashes of media which matched it.
+	GetMediaHashes(ctx context.Context, url string) ([]MediaHash, error)
+	// GetMediaHash returns a stored hash or ErrNotFound if there is none.
+	// `value` of MediaHashKeyLength is matched by prefix, and the most recently seen hash is returned.
+	GetMediaHash(ctx context.Context, feedID ID, hashType, value string) (*MediaHash, error)
+	// DeleteMediaHash deletes a stored hash, so that media with this hash are no longer considered duplicate.
+	DeleteMediaHash(ctx context.Context, feedID ID, hashType, value string) (int64, error)
+	// ResetMediaHash resets collisions of a stored hash, so that media dropped as duplicate is considered unique.
+	// The hash is kept, so that further duplicates of the media are still detected.
+	ResetMediaHash(ctx context.Context, feedID ID, hashType, value string) (int64, error)
+	// GetMostCollidedMediaHashes returns up to `limit` hashes with the most collisions in the feed.
+	GetMostCollidedMediaHashes(ctx context.Context, feedID ID, limit int) ([]MediaHash, error)
+}
+
+// DuplicateListener is notified about media dropped as duplicates.
+type DuplicateListener interface {
+	// AfterDuplicate is called after media is dropped as duplicate.
+	// `hash` is the stored hash of the dropped media with MatchedURL set if available.
+	AfterDuplicate(ctx context.Context, hash *MediaHash) error
+}
+
+// MediaFileStorage keeps track of media files uploaded to Telegram.
+type MediaFileStorage interface {
+	// GetMediaFile returns a MediaFile by source `url` or by content `hash` (if it is not nil).
+	// If no MediaFile is found, (nil, nil)-tuple is returned.
+	GetMediaFile(ctx context.Context, url string, hash *MediaHash) (*MediaFile, error)
+	// SaveMediaFile creates or updates a MediaFile.
+	SaveMediaFile(ctx context.Context, file *MediaFile) error
+	// DeleteMediaFiles deletes all media files with `fileID`.
+	DeleteMediaFiles(ctx context.Context, fileID string) (int64, error)
 }
 
 // Tx represents a database transaction on "subscription data subset".
@@ -126,6 +165,12 @@ type Storage interface {
 	//   non-nil error – this sets Subscription error (applicable only to active subscriptions)
 	//   gormf.JSONB – this updates the Subscription data (applicable only to active subscriptions)
 	UpdateSubscription(ctx context.Context, header Header, value any) error
+	// GetSentItemIDs returns a subset of `itemIDs` which have already been sent within the subscription.
+	GetSentItemIDs(ctx context.Context, header Header, itemIDs []string) (colf.Set[string], error)
+	// SaveSentItems marks items as sent. Items which are already marked as sent are ignored.
+	SaveSentItems(ctx context.Context, items []SentItem) error
+	// DeleteSentItems deletes all `vendor` items sent before `until`.
+	DeleteSentItems(ctx context.Context, vendor string, until time.Time) (int64, error)
 }
 
 // TaskExecutor is responsible for running background subscription update tasks.
@@ -134,7 +179,34 @@ type TaskExecutor interface {
 	Submit(id any, task Task)
 }
 
+// MediaGroupRef is a reference to several media resolved from a single URL (like an album).
+// Get returns only the first media, so that receivers which are unable to send media groups still work.
+type MediaGroupRef interface {
+	receiver.MediaRef
+	// GetAll returns references to all media in the group.
+	GetAll(ctx context.Context) ([]receiver.MediaRef, error)
+}
+
+// MediaOptions configure media mediation.
+type MediaOptions struct {
+	// DedupKey enables media deduplication within the scope of the key.
+	DedupKey *ID
+	// DedupScope is the name of dedup scope overriding scopes configured for DedupKey.
+	// LocalDedupScope limits deduplication to DedupKey only.
+	DedupScope string
+	// Spoiler covers media with a spoiler when sent.
+	Spoiler bool
+	// Document sends images as documents in order to preserve their quality.
+	Document bool
+	// Video contains video attributes known to the vendor (like dimensions from the board API).
+	// They are used for attributes which cannot be probed from the media itself.
+	Video *media.VideoMeta
+}
+
 // Mediator is responsible for downloading and converting media files.
 type Mediator interface {
-	Mediate(ctx context.Context, url string, dedupKey *ID) receiver.MediaRef
+	Mediate(ctx context.Context, url string, options MediaOptions) receiver.MediaRef
+	// MediateAll is like Mediate, but resolves all media available by `url` (like album items).
+	// Media are sent as a media group if the receiver supports it.
+	MediateAll(ctx context.Context, url string, options MediaOptions) MediaGroupRef
 }
@@ -0,0 +1,222 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/pkg/errors"
+)
+
+// DownloadOptions configure downloads into local files.
+type DownloadOptions struct {
+	// Parallelism is the maximum number of chunks downloaded concurrently.
+	// Values less than 2 disable parallel downloads.
+	Parallelism int
+	// ChunkSize is the size of a single chunk for parallel downloads.
+	ChunkSize Size
+	// Retries is the number of times a download is resumed after a failure.
+	Retries int
+}
+
+// Downloader is a Ref which is able to download itself into a local file
+// more efficiently than by copying its input (for instance, with ranged requests).
+type Downloader interface {
+	Ref
+	// Download writes the media into the file and returns the number of bytes written.
+	Download(ctx context.Context, file flu.File, options DownloadOptions) (int64, error)
+}
+
+// Download downloads the media into the file.
+// If the server accepts ranged requests, interrupted downloads are resumed from the last received byte,
+// and large files are downloaded in several chunks concurrently.
+// The resulting file size is verified against Content-Length.
+func (r HTTPRef) Download(ctx context.Context, file flu.File, options DownloadOptions) (int64, error) {
+	var size Size
+	meta, acceptRanges, err := r.head(ctx)
+	switch {
+	case err == nil:
+		size = meta.Size
+	case r.Meta != nil:
+		// some servers do not support HEAD requests, so ranges are not used
+		size = r.Meta.Size
+	}
+
+	out, err := os.OpenFile(file.String(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "open file")
+	}
+
+	defer flu.CloseQuietly(out)
+
+	if acceptRanges && size > 0 && options.Parallelism > 1 && options.ChunkSize > 0 && size > options.ChunkSize {
+		err = r.downloadParallel(ctx, out, int64(size), options)
+	} else {
+		err = r.downloadSequential(ctx, out, acceptRanges, options.Retries)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	stat, err := out.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "stat file")
+	}
+
+	if size > 0 && stat.Size() != int64(size) {
+		return 0, errors.Errorf("downloaded %s instead of %s", Size(stat.Size()), size)
+	}
+
+	return stat.Size(), nil
+}
+
+// downloadSequential downloads the file in a single stream resuming it after failures if possible.
+func (r HTTPRef) downloadSequential(ctx context.Context, out *os.File, acceptRanges bool, retries int) error {
+	var offset int64
+	for attempt := 0; ; attempt++ {
+		n, err := r.getRange(ctx, out, offset, -1)
+		offset += n
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= retries || !isRetryable(ctx, err) {
+			return err
+		}
+
+		if !acceptRanges {
+			// download needs to be restarted from the beginning
+			if err := out.Truncate(0); err != nil {
+				return errors.Wrap(err, "truncate file")
+			}
+
+			offset = 0
+		}
+	}
+}
+
+// downloadParallel downloads the file in chunks concurrently.
+// Every chunk is resumed after failures independently.
+func (r HTTPRef) downloadParallel(ctx context.Context, out *os.File, size int64, options DownloadOptions) error {
+	if err := out.Truncate(size); err != nil {
+		return errors.Wrap(err, "allocate file")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunkSize := int64(options.ChunkSize)
+	chunks := make(chan int64)
+	go func() {
+		defer close(chunks)
+		for start := int64(0); start < size; start += chunkSize {
+			select {
+			case chunks <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		work     sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i := 0; i < options.Parallelism; i++ {
+		work.Add(1)
+		go func() {
+			defer work.Done()
+			for start := range chunks {
+				end := start + chunkSize - 1
+				if end >= size {
+					end = size - 1
+				}
+
+				if err := r.downloadChunk(ctx, out, start, end, options.Retries); err != nil {
+					errOnce.Do(func() {
+						firstErr = errors.Wrapf(err, "download chunk %d-%d", start, end)
+						cancel()
+					})
+
+					return
+				}
+			}
+		}()
+	}
+
+	work.Wait()
+	return firstErr
+}
+
+func (r HTTPRef) downloadChunk(ctx context.Context, out *os.File, start, end int64, retries int) error {
+	for attempt := 0; ; attempt++ {
+		n, err := r.getRange(ctx, out, start, end)
+		start += n
+		if err == nil {
+			if start <= end {
+				return io.ErrUnexpectedEOF
+			}
+
+			return nil
+		}
+
+		if attempt >= retries || !isRetryable(ctx, err) {
+			return err
+		}
+	}
+}
+
+// getRange downloads bytes from start to end (inclusive) and writes them to the file at the same offset.
+// Negative end means the end of file. It returns the number of bytes written even if an error occurs.
+func (r HTTPRef) getRange(ctx context.Context, out *os.File, start, end int64) (int64, error) {
+	req := httpf.GET(r.URL)
+	status := http.StatusOK
+	if start > 0 || end >= 0 {
+		value := fmt.Sprintf("bytes=%d-", start)
+		if end >= 0 {
+			value += fmt.Sprint(end)
+		}
+
+		req = req.Header("Range", value)
+		status = http.StatusPartialContent
+	}
+
+	var n int64
+	err := req.Exchange(ctx, r.Client).
+		CheckStatus(status).
+		HandleFunc(func(resp *http.Response) error {
+			var body io.Reader = resp.Body
+			if end >= 0 {
+				body = io.LimitReader(body, end-start+1)
+			}
+
+			var err error
+			n, err = io.Copy(io.NewOffsetWriter(out, start), body)
+			return err
+		}).
+		Error()
+	return n, err
+}
+
+// isRetryable checks if the download may be resumed after the error.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrEgressDenied) {
+		return false
+	}
+
+	var statusErr httpf.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+
+	// connection errors
+	return true
+}
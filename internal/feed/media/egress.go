@@ -0,0 +1,211 @@
+package media
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/me3x"
+	"github.com/pkg/errors"
+)
+
+// ErrEgressDenied is returned when an outgoing request is denied by EgressPolicy.
+var ErrEgressDenied = errors.New("denied by egress policy")
+
+// EgressRules match hosts (along with their subdomains) and IP networks.
+type EgressRules struct {
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// ParseEgressRules parses host names, IP addresses and networks in CIDR notation.
+func ParseEgressRules(values []string) (EgressRules, error) {
+	var rules EgressRules
+	for _, value := range values {
+		switch {
+		case strings.Contains(value, "/"):
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return rules, errors.Wrapf(err, "parse network %s", value)
+			}
+
+			rules.nets = append(rules.nets, network)
+
+		case net.ParseIP(value) != nil:
+			ip := net.ParseIP(value)
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			rules.nets = append(rules.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+		default:
+			rules.hosts = append(rules.hosts, strings.ToLower(strings.TrimSuffix(value, ".")))
+		}
+	}
+
+	return rules, nil
+}
+
+func (r EgressRules) empty() bool {
+	return len(r.hosts) == 0 && len(r.nets) == 0
+}
+
+func (r EgressRules) matchHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range r.hosts {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r EgressRules) matchIP(ip net.IP) bool {
+	for _, network := range r.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EgressPolicy restricts outgoing HTTP requests in order to protect internal network from being accessed
+// via URLs found in feeds (SSRF). Addresses are checked after DNS resolution and connections are made
+// to checked addresses only, so that DNS responses can not be changed in between.
+type EgressPolicy struct {
+	// AllowPrivate allows private, loopback, link-local and unspecified addresses.
+	AllowPrivate bool
+	// Allow restricts outgoing requests to the listed hosts and networks if not empty.
+	// Matching hosts may resolve to private addresses only if AllowPrivate is set,
+	// while matching networks are allowed regardless of it.
+	Allow EgressRules
+	// Deny contains hosts and networks which are always denied.
+	Deny EgressRules
+	// MaxRedirects is the maximum number of redirects to follow.
+	MaxRedirects int
+	// Metrics receives counters of blocked requests.
+	Metrics me3x.Registry
+}
+
+// Apply makes the client enforce the policy. Requests are executed with the passed transport,
+// whose DialContext is replaced.
+func (p *EgressPolicy) Apply(client *http.Client, transport *http.Transport) {
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return p.dial(ctx, dialer, network, address)
+	}
+
+	client.Transport = p.roundTripper(transport)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > p.MaxRedirects {
+			return p.deny("redirects", "stopped after %d redirects", p.MaxRedirects)
+		}
+
+		return nil
+	}
+}
+
+func (p *EgressPolicy) roundTripper(transport http.RoundTripper) http.RoundTripper {
+	return httpf.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if host := req.URL.Hostname(); p.Deny.matchHost(host) {
+			return nil, p.deny("deny", "host %s", host)
+		}
+
+		return transport.RoundTrip(req)
+	})
+}
+
+// CheckHost checks if the host may be accessed by external tools (like yt-dlp) which fetch URLs by themselves.
+// All resolved addresses must be allowed since the tool may connect to any of them.
+func (p *EgressPolicy) CheckHost(ctx context.Context, host string) error {
+	if p.Deny.matchHost(host) {
+		return p.deny("deny", "host %s", host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if err := p.checkIP(host, addr.IP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dial resolves the host and connects to the first of its addresses allowed by the policy.
+func (p *EgressPolicy) dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		if err := p.checkIP(host, addr.IP); err != nil {
+			lastErr = err
+			continue
+		}
+
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = errors.Errorf("no addresses for %s", host)
+	}
+
+	return nil, lastErr
+}
+
+func (p *EgressPolicy) checkIP(host string, ip net.IP) error {
+	switch {
+	case p.Deny.matchIP(ip):
+		return p.deny("deny", "address %s of %s", ip, host)
+	case p.Allow.matchIP(ip):
+		return nil
+	case !p.Allow.empty() && !p.Allow.matchHost(host):
+		return p.deny("allow", "address %s of %s is not allowed", ip, host)
+	case p.AllowPrivate || !isPrivateIP(ip):
+		return nil
+	default:
+		return p.deny("private", "private address %s of %s", ip, host)
+	}
+}
+
+func (p *EgressPolicy) deny(reason, format string, args ...any) error {
+	if p.Metrics != nil {
+		labels := make(me3x.Labels, 0, 1).
+			Add("reason", reason)
+		p.Metrics.Counter("blocked", labels).Inc()
+	}
+
+	return errors.Wrapf(ErrEgressDenied, format, args...)
+}
+
+// isPrivateIP checks if the address is not routable over the public internet.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip)
+}
+
+// carrierGradeNAT is the shared address space (RFC 6598) which is used by some cloud providers internally.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
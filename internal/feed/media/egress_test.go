@@ -0,0 +1,150 @@
+package media
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestEgressPolicyCheckIP(t *testing.T) {
+	mustParse := func(values ...string) EgressRules {
+		rules, err := ParseEgressRules(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rules
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy EgressPolicy
+		host   string
+		ip     string
+		denied bool
+	}{
+		{
+			name: "public",
+			host: "example.com",
+			ip:   "93.184.216.34",
+		},
+		{
+			name:   "private",
+			host:   "internal.example.com",
+			ip:     "10.0.0.1",
+			denied: true,
+		},
+		{
+			name:   "private allowed",
+			policy: EgressPolicy{AllowPrivate: true},
+			host:   "internal.example.com",
+			ip:     "10.0.0.1",
+		},
+		{
+			name:   "denied network",
+			policy: EgressPolicy{AllowPrivate: true, Deny: mustParse("10.0.0.0/8")},
+			host:   "internal.example.com",
+			ip:     "10.0.0.1",
+			denied: true,
+		},
+		{
+			name:   "allowed host",
+			policy: EgressPolicy{Allow: mustParse("example.com")},
+			host:   "cdn.example.com",
+			ip:     "93.184.216.34",
+		},
+		{
+			name:   "host not in allowlist",
+			policy: EgressPolicy{Allow: mustParse("example.com")},
+			host:   "example.org",
+			ip:     "93.184.216.35",
+			denied: true,
+		},
+		{
+			name:   "allowed host resolving to private address",
+			policy: EgressPolicy{Allow: mustParse("example.com")},
+			host:   "internal.example.com",
+			ip:     "10.0.0.1",
+			denied: true,
+		},
+		{
+			name:   "allowed host resolving to private address with private allowed",
+			policy: EgressPolicy{AllowPrivate: true, Allow: mustParse("example.com")},
+			host:   "internal.example.com",
+			ip:     "10.0.0.1",
+		},
+		{
+			name:   "allowed network",
+			policy: EgressPolicy{Allow: mustParse("10.0.0.0/8")},
+			host:   "minio.local",
+			ip:     "10.0.0.1",
+		},
+		{
+			name:   "address not in allowlist",
+			policy: EgressPolicy{Allow: mustParse("10.0.0.0/8")},
+			host:   "example.com",
+			ip:     "93.184.216.34",
+			denied: true,
+		},
+		{
+			name:   "denied network takes precedence",
+			policy: EgressPolicy{Allow: mustParse("10.0.0.0/8"), Deny: mustParse("10.0.0.1")},
+			host:   "minio.local",
+			ip:     "10.0.0.1",
+			denied: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.checkIP(tc.host, net.ParseIP(tc.ip))
+			switch {
+			case tc.denied && !errors.Is(err, ErrEgressDenied):
+				t.Errorf("expected denial, got %v", err)
+			case !tc.denied && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestEgressPolicyCheckHost(t *testing.T) {
+	deny, err := ParseEgressRules([]string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy EgressPolicy
+		host   string
+		denied bool
+	}{
+		{
+			name:   "loopback",
+			host:   "127.0.0.1",
+			denied: true,
+		},
+		{
+			name:   "loopback allowed",
+			policy: EgressPolicy{AllowPrivate: true},
+			host:   "127.0.0.1",
+		},
+		{
+			name:   "denied host",
+			policy: EgressPolicy{Deny: deny},
+			host:   "video.example.com",
+			denied: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.CheckHost(context.Background(), tc.host)
+			switch {
+			case tc.denied && !errors.Is(err, ErrEgressDenied):
+				t.Errorf("expected denial, got %v", err)
+			case !tc.denied && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
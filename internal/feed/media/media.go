@@ -3,6 +3,7 @@ package media
 import (
 	"context"
 	"net/url"
+	"time"
 
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/syncf"
@@ -13,9 +14,17 @@ type Blob interface {
 	flu.Output
 }
 
+// RemoteInput is an input which is stored remotely and may be accessed by external tools (like ffmpeg) directly.
+type RemoteInput interface {
+	flu.Input
+	URL() (string, error)
+}
+
 type Meta struct {
 	MIMEType string
 	Size     Size
+	// Filename is the original name of the file, if known.
+	Filename string
 }
 
 type Ref = syncf.Ref[flu.Input]
@@ -30,7 +39,95 @@ type Resolver interface {
 	Resolve(ctx context.Context, source *url.URL) (MetaRef, error)
 }
 
+// ResolverRule describes URLs which a Resolver is applicable to.
+type ResolverRule struct {
+	// Hosts contains hosts the Resolver is applicable to. Subdomains are matched as well.
+	Hosts []string
+	// Patterns contains regular expressions matching URLs the Resolver is applicable to.
+	Patterns []string
+	// Priority defines the order in which applicable resolvers are tried (higher first).
+	Priority int
+}
+
+// RuleResolver is a Resolver which declares URLs it is applicable to.
+// Resolvers which do not implement this interface are tried for all URLs.
+type RuleResolver interface {
+	Resolver
+	ResolverRule() ResolverRule
+}
+
+// MultiResolver is a Resolver which may resolve a single URL to several media (like album items).
+type MultiResolver interface {
+	Resolver
+	// ResolveAll returns all media available by source URL.
+	// It returns nil if the resolver is not applicable to the URL.
+	ResolveAll(ctx context.Context, source *url.URL) ([]MetaRef, error)
+}
+
+// AnyType matches any MIME type in Conversion source.
+const AnyType = "*"
+
+// Conversion is a conversion of media from one MIME type to another.
+type Conversion struct {
+	From string
+	To   string
+}
+
+func (c Conversion) String() string {
+	return c.From + " => " + c.To
+}
+
 type Converter interface {
 	String() string
-	Convert(ctx context.Context, ref Ref, targetType string) (MetaRef, error)
+	// Conversions returns conversions supported by the Converter.
+	// Conversions from AnyType are applicable to all source types.
+	Conversions() []Conversion
+	Convert(ctx context.Context, ref Ref, conversion Conversion) (MetaRef, error)
+}
+
+// Compressor reduces media size so that it fits into maxSize.
+type Compressor interface {
+	String() string
+	Compress(ctx context.Context, ref Ref, mimeType string, maxSize Size) (MetaRef, error)
+}
+
+// Hash is a perceptual media hash.
+// Multi-frame hashes contain a 64-bit value per frame.
+type Hash struct {
+	Type   string
+	Values []uint64
+}
+
+type Hasher interface {
+	String() string
+	Hash(ctx context.Context, ref Ref, mimeType string) (*Hash, error)
+}
+
+// AudioMeta contains audio attributes displayed by Telegram.
+type AudioMeta struct {
+	Duration  time.Duration
+	Title     string
+	Performer string
+}
+
+// AudioProber extracts audio attributes from media.
+type AudioProber interface {
+	String() string
+	ProbeAudio(ctx context.Context, ref Ref, mimeType string) (*AudioMeta, error)
+}
+
+// VideoMeta contains video attributes displayed by Telegram.
+// Zero values mean that the attribute is unknown.
+type VideoMeta struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+	// Thumbnail is a JPEG image not larger than 320x320 and 200 kB.
+	Thumbnail flu.Input
+}
+
+// VideoProber extracts video attributes from media.
+type VideoProber interface {
+	String() string
+	ProbeVideo(ctx context.Context, ref Ref, mimeType string) (*VideoMeta, error)
 }
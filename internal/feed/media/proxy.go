@@ -0,0 +1,197 @@
+package media
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/pkg/errors"
+)
+
+// hopHeaders are connection-specific headers which are not forwarded by EgressProxy.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// EgressProxy is a local HTTP proxy which enforces EgressPolicy for external tools (like yt-dlp)
+// which fetch URLs by themselves. Hosts are resolved and checked by the proxy and connections
+// are made to checked addresses only, so redirects and requests to other hosts are checked as well.
+type EgressProxy struct {
+	policy    *EgressPolicy
+	dialer    *net.Dialer
+	transport http.RoundTripper
+	listener  net.Listener
+	server    *http.Server
+	ctx       context.Context
+	cancel    func()
+}
+
+// NewEgressProxy starts an EgressProxy on a random loopback port.
+// It should be closed after use.
+func NewEgressProxy(policy *EgressPolicy) (*EgressProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &EgressProxy{
+		policy:   policy,
+		dialer:   &net.Dialer{},
+		listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	transport := httpf.NewDefaultTransport()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return policy.dial(ctx, p.dialer, network, address)
+	}
+
+	p.transport = policy.roundTripper(transport)
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: time.Minute}
+	go func() { _ = p.server.Serve(listener) }()
+	return p, nil
+}
+
+// URL returns the proxy URL to be passed to the external tool.
+func (p *EgressProxy) URL() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// Close stops the proxy and closes all tunnels.
+func (p *EgressProxy) Close() error {
+	p.cancel()
+	return p.server.Close()
+}
+
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+	} else {
+		p.forward(w, r)
+	}
+}
+
+// tunnel connects the client to the requested address if it is allowed by the policy.
+func (p *EgressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if p.policy.Deny.matchHost(host) {
+		p.fail(w, p.policy.deny("deny", "host %s", host))
+		return
+	}
+
+	server, err := p.policy.dial(r.Context(), p.dialer, "tcp", r.Host)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = server.Close()
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		_ = server.Close()
+		return
+	}
+
+	// tunnels are limited by the tool lifetime rather than by server timeouts
+	_ = client.SetDeadline(time.Time{})
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		_ = client.Close()
+		_ = server.Close()
+		return
+	}
+
+	go p.pipe(client, buf.Reader, server)
+}
+
+// pipe copies data between the client and the server until either of them closes the connection.
+func (p *EgressProxy) pipe(client net.Conn, clientReader io.Reader, server net.Conn) {
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			_ = client.Close()
+			_ = server.Close()
+		})
+	}
+
+	stop := context.AfterFunc(p.ctx, closeAll)
+	defer stop()
+
+	go func() {
+		_, _ = io.Copy(server, clientReader)
+		closeAll()
+	}()
+
+	_, _ = io.Copy(client, server)
+	closeAll()
+}
+
+// forward executes plain HTTP requests with the policy enforced.
+// Redirects are returned to the client, so that they pass through the proxy as well.
+func (p *EgressProxy) forward(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Scheme != "http" || r.URL.Host == "" {
+		http.Error(w, "absolute http URL is required", http.StatusBadRequest)
+		return
+	}
+
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	removeHopHeaders(req.Header)
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+func (p *EgressProxy) fail(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	if errors.Is(err, ErrEgressDenied) {
+		status = http.StatusForbidden
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, key := range hopHeaders {
+		header.Del(key)
+	}
+}
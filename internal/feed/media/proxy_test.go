@@ -0,0 +1,83 @@
+package media
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestEgressProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	for _, tc := range []struct {
+		name   string
+		policy EgressPolicy
+		status int
+	}{
+		{
+			name:   "private address",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "private address allowed",
+			policy: EgressPolicy{AllowPrivate: true},
+			status: http.StatusNoContent,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, err := NewEgressProxy(&tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer proxy.Close()
+			proxyURL, err := url.Parse(proxy.URL())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}}
+
+			defer client.CloseIdleConnections()
+
+			// plain requests are forwarded by the proxy
+			resp, err := client.Get(plain.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_ = resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("expected status %d for http, got %d", tc.status, resp.StatusCode)
+			}
+
+			// https requests are tunneled through CONNECT
+			resp, err = client.Get(secure.URL)
+			switch {
+			case tc.status == http.StatusForbidden && err == nil:
+				_ = resp.Body.Close()
+				t.Errorf("expected tunnel to be denied, got %d", resp.StatusCode)
+			case tc.status != http.StatusForbidden && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case err == nil:
+				_ = resp.Body.Close()
+				if resp.StatusCode != tc.status {
+					t.Errorf("expected status %d for https, got %d", tc.status, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
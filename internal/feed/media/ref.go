@@ -2,8 +2,11 @@ package media
 
 import (
 	"context"
+	"io"
 	"mime"
 	"net/http"
+	"os"
+	"path"
 	"strconv"
 
 	"github.com/jfk9w-go/flu"
@@ -23,8 +26,18 @@ func (r HTTPRef) GetMeta(ctx context.Context) (*Meta, error) {
 		return r.Meta, nil
 	}
 
-	var m Meta
-	return &m, r.exchange(ctx, http.MethodHead).HandleFunc(func(resp *http.Response) error {
+	meta, _, err := r.head(ctx)
+	return meta, err
+}
+
+// head requests media metadata and checks whether the server accepts ranged requests.
+func (r HTTPRef) head(ctx context.Context) (*Meta, bool, error) {
+	var (
+		m            Meta
+		acceptRanges bool
+	)
+
+	err := r.exchange(ctx, http.MethodHead).HandleFunc(func(resp *http.Response) error {
 		contentType := resp.Header.Get("Content-Type")
 		if contentType == "" {
 			return errors.New("content type is empty")
@@ -44,8 +57,17 @@ func (r HTTPRef) GetMeta(ctx context.Context) (*Meta, error) {
 			m.Size = Size(size)
 		}
 
+		if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+			if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+				m.Filename = path.Base(params["filename"])
+			}
+		}
+
+		acceptRanges = resp.Header.Get("Accept-Ranges") == "bytes"
 		return nil
 	}).Error()
+
+	return &m, acceptRanges, err
 }
 
 func (r HTTPRef) Get(ctx context.Context) (flu.Input, error) {
@@ -84,3 +106,28 @@ type LazyRef struct {
 func (r LazyRef) GetMeta(ctx context.Context) (*Meta, error) {
 	return r.Meta, nil
 }
+
+// TempFile is a file in a temporary directory which is going to be removed.
+// It is not a flu.File, so that blob storage takes it over instead of using it directly.
+type TempFile string
+
+func (f TempFile) Reader() (io.Reader, error) {
+	return os.Open(string(f))
+}
+
+// MoveTo moves the file to `file` and returns its size.
+// The file is copied if it can not be renamed (for instance, across file systems).
+func (f TempFile) MoveTo(file flu.File) (int64, error) {
+	if err := os.Rename(string(f), file.String()); err != nil {
+		if _, err := flu.Copy(f, file); err != nil {
+			return 0, err
+		}
+	}
+
+	stat, err := os.Stat(file.String())
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
@@ -0,0 +1,52 @@
+package feed
+
+import (
+	"context"
+	"time"
+
+	"github.com/jfk9w-go/flu/colf"
+	"github.com/jfk9w-go/flu/syncf"
+)
+
+// SentItems is a helper for vendors which need to keep track of subscription items which have already been sent.
+type SentItems struct {
+	Storage Storage
+	Clock   syncf.Clock
+	// Vendor is the vendor ID used for cleaning up stale items.
+	Vendor string
+	// TTL is how long to keep sent items in storage.
+	TTL time.Duration
+}
+
+// Sent returns a subset of `itemIDs` which have already been sent within the subscription.
+func (s *SentItems) Sent(ctx context.Context, header Header, itemIDs []string) (colf.Set[string], error) {
+	if len(itemIDs) == 0 {
+		return make(colf.Set[string]), nil
+	}
+
+	return s.Storage.GetSentItemIDs(ctx, header, itemIDs)
+}
+
+// Add marks items as sent within the subscription.
+func (s *SentItems) Add(ctx context.Context, header Header, itemIDs ...string) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+
+	now := s.Clock.Now()
+	items := make([]SentItem, len(itemIDs))
+	for i, itemID := range itemIDs {
+		items[i] = SentItem{
+			Header: header,
+			ItemID: itemID,
+			SentAt: now,
+		}
+	}
+
+	return s.Storage.SaveSentItems(ctx, items)
+}
+
+// Clean deletes items which were sent earlier than TTL ago.
+func (s *SentItems) Clean(ctx context.Context) (int64, error) {
+	return s.Storage.DeleteSentItems(ctx, s.Vendor, s.Clock.Now().Add(-s.TTL))
+}
@@ -3,12 +3,15 @@ package feed
 import (
 	"context"
 	"fmt"
+	"math/bits"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jfk9w-go/flu/gormf"
 	"github.com/jfk9w-go/flu/me3x"
 	"github.com/jfk9w-go/telegram-bot-api/ext/html"
+	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -53,6 +56,54 @@ type Draft struct {
 	Data  any
 }
 
+// Spoiler defines whether media should be covered with a spoiler in Telegram.
+type Spoiler string
+
+const (
+	// SpoilerFollow covers media with a spoiler if the source marks it as NSFW or spoiler.
+	SpoilerFollow Spoiler = ""
+	// SpoilerAlways covers all media with a spoiler.
+	SpoilerAlways Spoiler = "always"
+	// SpoilerNever never covers media with a spoiler.
+	SpoilerNever Spoiler = "never"
+)
+
+// ParseSpoiler parses spoiler subscription option: `s` stands for SpoilerAlways and `!s` for SpoilerNever.
+func ParseSpoiler(option string) (Spoiler, bool) {
+	switch option {
+	case "s":
+		return SpoilerAlways, true
+	case "!s":
+		return SpoilerNever, true
+	default:
+		return SpoilerFollow, false
+	}
+}
+
+// Apply returns true if media should be covered with a spoiler given the source flag.
+func (s Spoiler) Apply(source bool) bool {
+	switch s {
+	case SpoilerAlways:
+		return true
+	case SpoilerNever:
+		return false
+	default:
+		return source
+	}
+}
+
+// LocalDedupScope limits media deduplication to the feed itself.
+const LocalDedupScope = "."
+
+// ParseDedupScope parses dedup scope subscription option like `d=memes`.
+func ParseDedupScope(option string) (string, bool) {
+	if scope, ok := strings.CutPrefix(option, "d="); ok && scope != "" {
+		return scope, true
+	}
+
+	return "", false
+}
+
 type Event struct {
 	Time   time.Time `gorm:"not null;index"`
 	Type   string    `gorm:"not null;index:idx_event"`
@@ -68,16 +119,162 @@ type WriteHTML func(html *html.Writer) error
 
 type Task func(context.Context) error
 
+// MediaHashKeyLength is the maximum hash value length used to reference a stored hash.
+// Longer values (like ones of multi-frame hashes) are truncated and matched by prefix.
+// This allows to fit hash references into Telegram callback data.
+const MediaHashKeyLength = 32
+
+// MediaHashSegments is the number of 16-bit segments a perceptual hash is split into.
+// Segments are used for similarity search based on Hamming distance.
+const MediaHashSegments = 4
+
 type MediaHash struct {
-	FeedID     ID        `gorm:"primaryKey"`
-	URL        string    `gorm:"not null"`
-	Type       string    `gorm:"primaryKey;column:hash_type"`
-	Value      string    `gorm:"primaryKey;column:hash"`
-	FirstSeen  time.Time `gorm:"not null"`
-	LastSeen   time.Time `gorm:"not null"`
-	Collisions int64     `gorm:"not null"`
+	FeedID     ID          `gorm:"primaryKey"`
+	URL        string      `gorm:"not null"`
+	Type       string      `gorm:"primaryKey;column:hash_type"`
+	Value      string      `gorm:"primaryKey;column:hash"`
+	FirstSeen  time.Time   `gorm:"not null"`
+	LastSeen   time.Time   `gorm:"not null"`
+	Collisions int64       `gorm:"not null"`
+	MatchedURL null.String `gorm:"column:matched_url"`
+	Seg0       null.Int    `gorm:"column:seg0"`
+	Seg1       null.Int    `gorm:"column:seg1"`
+	Seg2       null.Int    `gorm:"column:seg2"`
+	Seg3       null.Int    `gorm:"column:seg3"`
+
+	// Spoiler and Document are options the media has been mediated with,
+	// so that media dropped as duplicate may be sent later the same way.
+	Spoiler  bool `gorm:"not null;default:false"`
+	Document bool `gorm:"not null;default:false"`
+
+	// Scope contains IDs of other feeds sharing media deduplication with FeedID.
+	// Hashes are still stored per feed.
+	Scope []ID `gorm:"-"`
 }
 
 func (h *MediaHash) TableName() string {
 	return "blob"
 }
+
+// SetPerceptual sets a perceptual hash value along with its segments.
+// Multi-frame hashes contain a 64-bit value per frame, and segments are taken from the first one.
+func (h *MediaHash) SetPerceptual(hashType string, values ...uint64) {
+	h.Type = hashType
+	h.Value = FormatPerceptual(values)
+	segments := h.segments()
+	for i := range segments {
+		*segments[i] = null.IntFrom(int64(values[0] >> (16 * (MediaHashSegments - 1 - i)) & 0xffff))
+	}
+}
+
+// Perceptual returns perceptual hash values if this is a perceptual hash.
+func (h *MediaHash) Perceptual() ([]uint64, bool) {
+	if !h.Seg0.Valid {
+		return nil, false
+	}
+
+	values, err := ParsePerceptual(h.Value)
+	return values, err == nil
+}
+
+// perceptualValueLength is the length of a 64-bit value in a multi-frame perceptual hash string.
+const perceptualValueLength = 16
+
+// FormatPerceptual returns a string representation of perceptual hash values.
+// Single values are not padded for compatibility with previously stored hashes.
+func FormatPerceptual(values []uint64) string {
+	if len(values) == 1 {
+		return fmt.Sprintf("%x", values[0])
+	}
+
+	var b strings.Builder
+	for _, value := range values {
+		b.WriteString(fmt.Sprintf("%016x", value))
+	}
+
+	return b.String()
+}
+
+// ParsePerceptual parses a string representation of perceptual hash values.
+func ParsePerceptual(str string) ([]uint64, error) {
+	if len(str) <= perceptualValueLength {
+		value, err := strconv.ParseUint(str, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return []uint64{value}, nil
+	}
+
+	if len(str)%perceptualValueLength != 0 {
+		return nil, errors.Errorf("invalid perceptual hash length: %d", len(str))
+	}
+
+	values := make([]uint64, len(str)/perceptualValueLength)
+	for i := range values {
+		value, err := strconv.ParseUint(str[i*perceptualValueLength:(i+1)*perceptualValueLength], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// PerceptualDistance returns the largest Hamming distance between respective perceptual hash values.
+// Hashes with different number of values are never similar, so false is returned for them.
+func PerceptualDistance(a, b []uint64) (int, bool) {
+	if len(a) != len(b) {
+		return 0, false
+	}
+
+	distance := 0
+	for i := range a {
+		if d := bits.OnesCount64(a[i] ^ b[i]); d > distance {
+			distance = d
+		}
+	}
+
+	return distance, true
+}
+
+// Segments returns perceptual hash segment values.
+func (h *MediaHash) Segments() [MediaHashSegments]int64 {
+	var values [MediaHashSegments]int64
+	for i, segment := range h.segments() {
+		values[i] = segment.Int64
+	}
+
+	return values
+}
+
+func (h *MediaHash) segments() [MediaHashSegments]*null.Int {
+	return [MediaHashSegments]*null.Int{&h.Seg0, &h.Seg1, &h.Seg2, &h.Seg3}
+}
+
+// MediaFile is a media file which has already been uploaded to Telegram.
+// Its file ID may be used for sending the same media again without uploading it.
+type MediaFile struct {
+	URL       string      `gorm:"primaryKey"`
+	HashType  null.String `gorm:"index:idx_media_file_hash"`
+	Hash      null.String `gorm:"index:idx_media_file_hash"`
+	FileID    string      `gorm:"not null;index"`
+	MIMEType  string      `gorm:"not null;column:mime_type"`
+	CreatedAt time.Time   `gorm:"not null"`
+}
+
+func (f *MediaFile) TableName() string {
+	return "media_file"
+}
+
+type SentItem struct {
+	Header `gorm:"embedded"`
+	ItemID string    `gorm:"primaryKey"`
+	SentAt time.Time `gorm:"not null;index"`
+}
+
+func (i *SentItem) TableName() string {
+	return "sent_item"
+}
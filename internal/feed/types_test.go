@@ -0,0 +1,63 @@
+package feed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPerceptual(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []uint64
+		value  string
+	}{
+		{name: "single value", values: []uint64{0xabc}, value: "abc"},
+		{name: "multiple values", values: []uint64{0xabc, 0xffffffffffffffff},
+			value: "0000000000000abcffffffffffffffff"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hash MediaHash
+			hash.SetPerceptual("dhash", tc.values...)
+			if hash.Value != tc.value {
+				t.Errorf("expected value %s, got %s", tc.value, hash.Value)
+			}
+
+			if segments := hash.Segments(); segments[3] != int64(tc.values[0]&0xffff) {
+				t.Errorf("expected segments of the first value, got %v", segments)
+			}
+
+			values, ok := hash.Perceptual()
+			if !ok || !reflect.DeepEqual(values, tc.values) {
+				t.Errorf("expected values %x, got %x (%v)", tc.values, values, ok)
+			}
+		})
+	}
+}
+
+func TestParsePerceptualInvalid(t *testing.T) {
+	for _, value := range []string{"", "xyz", "0000000000000abcfff"} {
+		if values, err := ParsePerceptual(value); err == nil {
+			t.Errorf("expected error for %q, got %x", value, values)
+		}
+	}
+}
+
+func TestPerceptualDistance(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		a, b     []uint64
+		distance int
+		ok       bool
+	}{
+		{name: "equal", a: []uint64{1, 2}, b: []uint64{1, 2}, distance: 0, ok: true},
+		{name: "max of frames", a: []uint64{0, 0}, b: []uint64{0b1, 0b111}, distance: 3, ok: true},
+		{name: "different lengths", a: []uint64{0}, b: []uint64{0, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			distance, ok := PerceptualDistance(tc.a, tc.b)
+			if distance != tc.distance || ok != tc.ok {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tc.distance, tc.ok, distance, ok)
+			}
+		})
+	}
+}
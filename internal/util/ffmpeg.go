@@ -0,0 +1,278 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed"
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// FFmpegSource is an input for ffmpeg and ffprobe.
+// Local files and remote inputs are passed by path or URL, other inputs are piped through stdin.
+type FFmpegSource struct {
+	Path  string
+	Input flu.Input
+	// Protocols is the list of protocols ffmpeg and ffprobe are allowed to use for reading the source.
+	// It prevents media files (like HLS playlists) from making them access arbitrary files and URLs.
+	Protocols string
+}
+
+// NewFFmpegSource creates an FFmpegSource for the input.
+// URLs are piped so that they are fetched with the default HTTP client which enforces egress policy.
+// Piped inputs need to be readable multiple times since they may be probed before processing.
+func NewFFmpegSource(input flu.Input) (FFmpegSource, error) {
+	switch input := input.(type) {
+	case flu.File:
+		return FFmpegSource{Path: input.String(), Protocols: "file"}, nil
+	case media.RemoteInput:
+		url, err := input.URL()
+		if err != nil {
+			return FFmpegSource{}, errors.Wrap(err, "get url")
+		}
+
+		// remote inputs are served by blob storage which is trusted
+		return FFmpegSource{Path: url, Protocols: "http,https,tls,tcp"}, nil
+	default:
+		return FFmpegSource{Path: "pipe:", Input: input, Protocols: "pipe"}, nil
+	}
+}
+
+// GetFFmpegSource returns ffmpeg source for the media.
+// Inputs other than local files and remote blobs are buffered first since they may be read multiple times.
+// URLs are buffered as well, so that they are fetched with the default HTTP client which enforces egress policy.
+func GetFFmpegSource(ctx context.Context, blobs feed.Blobs, ref media.Ref, mimeType string) (FFmpegSource, error) {
+	input, err := ref.Get(ctx)
+	if err != nil {
+		return FFmpegSource{}, err
+	}
+
+	switch input.(type) {
+	case flu.File, media.RemoteInput:
+	default:
+		input, err = blobs.Buffer(mimeType, syncf.Val[flu.Input]{V: input}).Get(ctx)
+		if err != nil {
+			return FFmpegSource{}, err
+		}
+	}
+
+	return NewFFmpegSource(input)
+}
+
+func (s FFmpegSource) String() string {
+	if s.Input != nil {
+		return "pipe"
+	}
+
+	return s.Path
+}
+
+// Stream creates an ffmpeg stream reading from this source.
+func (s FFmpegSource) Stream(kwargs ...ffmpeg.KwArgs) *ffmpeg.Stream {
+	return ffmpeg.Input(s.Path, append(kwargs, s.args())...)
+}
+
+func (s FFmpegSource) args() ffmpeg.KwArgs {
+	return ffmpeg.KwArgs{"protocol_whitelist": s.Protocols}
+}
+
+// Run runs the output stream created with Stream.
+func (s FFmpegSource) Run(ctx context.Context, stream *ffmpeg.Stream) error {
+	if s.Input != nil {
+		reader, err := s.Input.Reader()
+		if err != nil {
+			return errors.Wrap(err, "open input")
+		}
+
+		defer flu.CloseQuietly(reader)
+		stream = stream.WithInput(reader)
+	}
+
+	stream.Context = ctx
+	return stream.Run()
+}
+
+// Probe returns ffprobe JSON output for the source.
+func (s FFmpegSource) Probe() (string, error) {
+	if s.Input == nil {
+		return ffmpeg.Probe(s.Path, s.args())
+	}
+
+	reader, err := s.Input.Reader()
+	if err != nil {
+		return "", errors.Wrap(err, "open input")
+	}
+
+	defer flu.CloseQuietly(reader)
+	return ffmpeg.ProbeReader(reader, s.args())
+}
+
+// ffprobeFormat is the container format section of ffprobe output.
+type ffprobeFormat struct {
+	Duration string            `json:"duration"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// ffprobeStream is a stream section of ffprobe output.
+type ffprobeStream struct {
+	CodecType string            `json:"codec_type"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Tags      map[string]string `json:"tags"`
+	SideData  []struct {
+		Rotation int `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeOutput struct {
+	Format  ffprobeFormat   `json:"format"`
+	Streams []ffprobeStream `json:"streams"`
+}
+
+func probe(source FFmpegSource) (*ffprobeOutput, error) {
+	output, err := source.Probe()
+	if err != nil {
+		return nil, err
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal([]byte(output), &probe); err != nil {
+		return nil, errors.Wrap(err, "parse output")
+	}
+
+	return &probe, nil
+}
+
+func probeFormat(source FFmpegSource) (*ffprobeFormat, error) {
+	probe, err := probe(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &probe.Format, nil
+}
+
+// dimensions returns display dimensions of the video stream.
+// Rotated videos (like the ones recorded with phones) have their dimensions swapped.
+func (s *ffprobeStream) dimensions() (int, int) {
+	rotation, _ := strconv.Atoi(s.Tags["rotate"])
+	for _, data := range s.SideData {
+		if data.Rotation != 0 {
+			rotation = data.Rotation
+		}
+	}
+
+	if rotation%180 != 0 {
+		return s.Height, s.Width
+	}
+
+	return s.Width, s.Height
+}
+
+func (f *ffprobeFormat) duration() (float64, error) {
+	duration, err := strconv.ParseFloat(f.Duration, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse duration %s", f.Duration)
+	}
+
+	if duration <= 0 {
+		return 0, errors.Errorf("invalid duration %f", duration)
+	}
+
+	return duration, nil
+}
+
+// tag returns the value of the tag. Tag names are case-insensitive since they differ between containers.
+func (f *ffprobeFormat) tag(name string) string {
+	for key, value := range f.Tags {
+		if strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// ProbeDuration returns media duration in seconds using ffprobe.
+func ProbeDuration(source FFmpegSource) (float64, error) {
+	format, err := probeFormat(source)
+	if err != nil {
+		return 0, err
+	}
+
+	return format.duration()
+}
+
+// ProbeAudio returns audio duration, title and performer using ffprobe.
+// Missing attributes are left empty.
+func ProbeAudio(source FFmpegSource) (*media.AudioMeta, error) {
+	format, err := probeFormat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	audio := &media.AudioMeta{
+		Title:     format.tag("title"),
+		Performer: format.tag("artist"),
+	}
+
+	if duration, err := format.duration(); err == nil {
+		audio.Duration = time.Duration(duration * float64(time.Second))
+	}
+
+	return audio, nil
+}
+
+// ProbeVideo returns video duration and dimensions using ffprobe.
+// Missing attributes are left empty.
+func ProbeVideo(source FFmpegSource) (*media.VideoMeta, error) {
+	probe, err := probe(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return probe.video(), nil
+}
+
+func (p *ffprobeOutput) video() *media.VideoMeta {
+	video := new(media.VideoMeta)
+	if duration, err := p.Format.duration(); err == nil {
+		video.Duration = time.Duration(duration * float64(time.Second))
+	}
+
+	for i := range p.Streams {
+		if stream := &p.Streams[i]; stream.CodecType == "video" {
+			video.Width, video.Height = stream.dimensions()
+			break
+		}
+	}
+
+	return video
+}
+
+// FFmpegOutput is an input which streams ffmpeg output.
+// ffmpeg is started when the reader is requested.
+type FFmpegOutput struct {
+	Context context.Context
+	Source  FFmpegSource
+	Stream  *ffmpeg.Stream
+}
+
+func (o FFmpegOutput) Reader() (io.Reader, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		err := o.Source.Run(o.Context, o.Stream.WithOutput(writer))
+		_ = writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
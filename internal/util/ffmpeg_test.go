@@ -0,0 +1,204 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/v4/internal/feed/media"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/syncf"
+)
+
+// testRemoteInput is a media.RemoteInput stub.
+type testRemoteInput struct {
+	flu.Bytes
+}
+
+func (testRemoteInput) URL() (string, error) {
+	return "https://s3.example.com/bucket/blob", nil
+}
+
+func TestNewFFmpegSource(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		input     flu.Input
+		path      string
+		piped     bool
+		protocols string
+	}{
+		{
+			name:      "file",
+			input:     flu.File("/tmp/video.mp4"),
+			path:      "/tmp/video.mp4",
+			protocols: "file",
+		},
+		{
+			name:      "url is piped",
+			input:     flu.URL("http://127.0.0.1/video.mp4"),
+			path:      "pipe:",
+			piped:     true,
+			protocols: "pipe",
+		},
+		{
+			name:      "remote input",
+			input:     testRemoteInput{},
+			path:      "https://s3.example.com/bucket/blob",
+			protocols: "http,https,tls,tcp",
+		},
+		{
+			name:      "bytes",
+			input:     flu.Bytes("data"),
+			path:      "pipe:",
+			piped:     true,
+			protocols: "pipe",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := NewFFmpegSource(tc.input)
+			switch {
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case source.Path != tc.path:
+				t.Errorf("expected path %q, got %q", tc.path, source.Path)
+			case (source.Input != nil) != tc.piped:
+				t.Errorf("expected piped %t, got input %v", tc.piped, source.Input)
+			case source.Protocols != tc.protocols:
+				t.Errorf("expected protocols %q, got %q", tc.protocols, source.Protocols)
+			}
+
+			args := source.Stream().Output("pipe:").GetArgs()
+			if !contains(args, "-protocol_whitelist", tc.protocols) {
+				t.Errorf("expected protocol whitelist in %v", args)
+			}
+		})
+	}
+}
+
+// testBlobs is a feed.Blobs stub which buffers inputs to a fixed file.
+type testBlobs struct {
+	mimeTypes []string
+}
+
+func (b *testBlobs) Buffer(mimeType string, ref media.Ref) media.MetaRef {
+	b.mimeTypes = append(b.mimeTypes, mimeType)
+	return media.LocalRef{Input: flu.File("/tmp/buffer")}
+}
+
+func (b *testBlobs) Acquire(ctx context.Context, input flu.Input) func() {
+	return func() {}
+}
+
+func TestGetFFmpegSource(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    flu.Input
+		path     string
+		buffered bool
+	}{
+		{
+			name:  "file",
+			input: flu.File("/tmp/video.mp4"),
+			path:  "/tmp/video.mp4",
+		},
+		{
+			name:  "remote input",
+			input: testRemoteInput{},
+			path:  "https://s3.example.com/bucket/blob",
+		},
+		{
+			name:     "url is buffered",
+			input:    flu.URL("http://127.0.0.1/video.mp4"),
+			path:     "/tmp/buffer",
+			buffered: true,
+		},
+		{
+			name:     "bytes are buffered",
+			input:    flu.Bytes("data"),
+			path:     "/tmp/buffer",
+			buffered: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			blobs := new(testBlobs)
+			source, err := GetFFmpegSource(context.Background(), blobs, syncf.Val[flu.Input]{V: tc.input}, "video/mp4")
+			switch {
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case source.Path != tc.path:
+				t.Errorf("expected path %q, got %q", tc.path, source.Path)
+			case (len(blobs.mimeTypes) > 0) != tc.buffered:
+				t.Errorf("expected buffered %t, got %v", tc.buffered, blobs.mimeTypes)
+			case tc.buffered && blobs.mimeTypes[0] != "video/mp4":
+				t.Errorf("expected buffer mime type video/mp4, got %q", blobs.mimeTypes[0])
+			}
+		})
+	}
+}
+
+func contains(args []string, key, value string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == key && args[i+1] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestFFprobeOutputVideo(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		output   string
+		duration time.Duration
+		width    int
+		height   int
+	}{
+		{
+			name:     "landscape",
+			output:   `{"format":{"duration":"12.5"},"streams":[{"codec_type":"audio"},{"codec_type":"video","width":1280,"height":720}]}`,
+			duration: 12500 * time.Millisecond,
+			width:    1280,
+			height:   720,
+		},
+		{
+			name:     "rotated with side data",
+			output:   `{"format":{"duration":"3"},"streams":[{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":-90}]}]}`,
+			duration: 3 * time.Second,
+			width:    1080,
+			height:   1920,
+		},
+		{
+			name:   "rotated with tag",
+			output: `{"format":{},"streams":[{"codec_type":"video","width":640,"height":480,"tags":{"rotate":"270"}}]}`,
+			width:  480,
+			height: 640,
+		},
+		{
+			name:   "upside down",
+			output: `{"format":{},"streams":[{"codec_type":"video","width":640,"height":480,"tags":{"rotate":"180"}}]}`,
+			width:  640,
+			height: 480,
+		},
+		{
+			name:     "no video stream",
+			output:   `{"format":{"duration":"60"},"streams":[{"codec_type":"audio"}]}`,
+			duration: time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var probe ffprobeOutput
+			if err := json.Unmarshal([]byte(tc.output), &probe); err != nil {
+				t.Fatal(err)
+			}
+
+			video := probe.video()
+			if video.Duration != tc.duration || video.Width != tc.width || video.Height != tc.height {
+				t.Errorf("expected %s %dx%d, got %s %dx%d",
+					tc.duration, tc.width, tc.height, video.Duration, video.Width, video.Height)
+			}
+		})
+	}
+}